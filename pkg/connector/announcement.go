@@ -0,0 +1,258 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"github.com/LagrangeDev/LagrangeGo/client/entity"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ptr"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/matrix"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type QQAnnouncementEvent struct {
+	GroupUin uint32
+	Notice   *entity.GroupNoticeFeed
+	qc       *QQClient
+}
+
+var (
+	_ bridgev2.RemoteMessage            = (*QQAnnouncementEvent)(nil)
+	_ bridgev2.RemoteEventWithTimestamp = (*QQAnnouncementEvent)(nil)
+	_ bridgev2.RemotePostHandler        = (*QQAnnouncementEvent)(nil)
+)
+
+func (evt *QQAnnouncementEvent) AddLogContext(c zerolog.Context) zerolog.Context {
+	return c.Str("notice_id", evt.Notice.NoticeID).Uint32("sender_id", evt.Notice.SenderID)
+}
+
+func (evt *QQAnnouncementEvent) GetPortalKey() networkid.PortalKey {
+	return evt.qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(evt.GroupUin))
+}
+
+func (evt *QQAnnouncementEvent) GetSender() bridgev2.EventSender {
+	return evt.qc.makeEventSender(fmt.Sprint(evt.Notice.SenderID))
+}
+
+func (evt *QQAnnouncementEvent) GetID() networkid.MessageID {
+	return qqid.MakeFakeMessageID(fmt.Sprint(evt.GroupUin), "notice-"+evt.Notice.NoticeID)
+}
+
+func (evt *QQAnnouncementEvent) GetTimestamp() time.Time {
+	return time.Unix(int64(evt.Notice.PublishTime), 0)
+}
+
+func (evt *QQAnnouncementEvent) GetType() bridgev2.RemoteEventType {
+	return bridgev2.RemoteEventMessage
+}
+
+func (evt *QQAnnouncementEvent) ConvertMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI) (*bridgev2.ConvertedMessage, error) {
+	text := noticeText(evt.Notice)
+
+	return &bridgev2.ConvertedMessage{
+		Parts: []*bridgev2.ConvertedMessagePart{{
+			Type: event.EventMessage,
+			Content: &event.MessageEventContent{
+				MsgType:       event.MsgNotice,
+				Body:          "Group announcement:\n\n" + text,
+				Format:        event.FormatHTML,
+				FormattedBody: "<strong>Group announcement</strong><br><br>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>"),
+			},
+		}},
+	}, nil
+}
+
+func (evt *QQAnnouncementEvent) PostHandle(ctx context.Context, portal *bridgev2.Portal) {
+	if !evt.qc.Main.Config.Announcements.Pin {
+		return
+	}
+
+	msg, err := evt.qc.Main.Bridge.DB.Message.GetFirstPartByID(ctx, evt.qc.UserLogin.ID, evt.GetID())
+	if err != nil || msg == nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to find announcement message to pin")
+		return
+	}
+
+	if err := evt.qc.pinEvent(ctx, portal, msg.MXID); err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to pin announcement")
+	}
+}
+
+func (qc *QQClient) pinEvent(ctx context.Context, portal *bridgev2.Portal, eventID id.EventID) error {
+	var content event.PinnedEventsEventContent
+	// bridgev2 has no API for reading state, so go through the appservice intent
+	if bot, ok := qc.Main.Bridge.Bot.(*matrix.ASIntent); ok {
+		_ = bot.Matrix.StateEvent(ctx, portal.MXID, event.StatePinnedEvents, "", &content)
+	}
+	if slices.Contains(content.Pinned, eventID) {
+		return nil
+	}
+	content.Pinned = append(content.Pinned, eventID)
+
+	_, err := qc.Main.Bridge.Bot.SendState(ctx, portal.MXID, event.StatePinnedEvents, "", &event.Content{
+		Parsed: &content,
+	}, time.Time{})
+	return err
+}
+
+// getTopicNotice returns the announcement to use as the initial topic of a group portal.
+// Once a portal has a topic announcement, the announcement poller keeps it up to date,
+// so it is only fetched for portals without one and only once per group.
+func (qc *QQClient) getTopicNotice(ctx context.Context, portal *bridgev2.Portal, groupUin uint32) *entity.GroupNoticeFeed {
+	if portal.Metadata.(*qqid.PortalMetadata).LastNoticeID != "" {
+		return nil
+	}
+	if cached, ok := qc.latestNotices.Load(groupUin); ok {
+		return cached.(*entity.GroupNoticeFeed)
+	}
+
+	notice, err := qc.getLatestNotice(groupUin)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to fetch group announcements")
+		return nil
+	}
+
+	return notice
+}
+
+// getLatestNotice returns the most recently published announcement of a group.
+func (qc *QQClient) getLatestNotice(groupUin uint32) (*entity.GroupNoticeFeed, error) {
+	notices, err := qc.Client.GetGroupNotice(groupUin)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *entity.GroupNoticeFeed
+	for _, notice := range notices {
+		if latest == nil || notice.PublishTime > latest.PublishTime {
+			latest = notice
+		}
+	}
+	qc.latestNotices.Store(groupUin, latest)
+
+	return latest, nil
+}
+
+func (qc *QQClient) announcementLoop(ctx context.Context) {
+	log := qc.UserLogin.Log.With().Str("action", "announcement loop").Logger()
+	ctx = log.WithContext(ctx)
+	interval := time.Duration(qc.Main.Config.Announcements.PollInterval) * time.Minute
+
+	for {
+		var portals []*bridgev2.Portal
+		if qc.IsLoggedIn() {
+			portals = qc.getGroupPortals(ctx)
+		}
+		if len(portals) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+			continue
+		}
+
+		// Spread the checks over the interval instead of fetching every group at once
+		spacing := interval / time.Duration(len(portals))
+		for _, portal := range portals {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(spacing):
+			}
+
+			if qc.IsLoggedIn() {
+				qc.checkAnnouncement(ctx, portal)
+			}
+		}
+	}
+}
+
+// getGroupPortals returns the portals of all groups that have a Matrix room.
+func (qc *QQClient) getGroupPortals(ctx context.Context) []*bridgev2.Portal {
+	var portals []*bridgev2.Portal
+	for groupUin := range qc.Client.GetCachedAllGroupsInfo() {
+		portal, err := qc.Main.Bridge.GetExistingPortalByKey(ctx, qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(groupUin)))
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Uint32("group_uin", groupUin).Msg("Failed to get portal")
+		} else if portal != nil && portal.MXID != "" {
+			portals = append(portals, portal)
+		}
+	}
+	return portals
+}
+
+func (qc *QQClient) checkAnnouncement(ctx context.Context, portal *bridgev2.Portal) {
+	groupUin, err := strconv.ParseUint(string(portal.ID), 10, 32)
+	if err != nil {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().Uint32("group_uin", uint32(groupUin)).Logger()
+
+	notice, err := qc.getLatestNotice(uint32(groupUin))
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to fetch group announcements")
+		return
+	} else if notice == nil {
+		return
+	}
+
+	meta := portal.Metadata.(*qqid.PortalMetadata)
+	if meta.LastNoticeID == notice.NoticeID {
+		return
+	}
+	// Don't flood the room with old announcements the first time a group is checked
+	if meta.LastNoticeID != "" {
+		qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &QQAnnouncementEvent{
+			GroupUin: uint32(groupUin),
+			Notice:   notice,
+			qc:       qc,
+		})
+	}
+
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.ChatInfoChange{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventChatInfoChange,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.Str("notice_id", notice.NoticeID)
+			},
+			PortalKey: portal.PortalKey,
+			Sender:    qc.makeEventSender(fmt.Sprint(notice.SenderID)),
+			Timestamp: time.Unix(int64(notice.PublishTime), 0),
+		},
+		ChatInfoChange: &bridgev2.ChatInfoChange{
+			ChatInfo: &bridgev2.ChatInfo{
+				Topic:        ptr.Ptr(noticeText(notice)),
+				ExtraUpdates: updateLastNotice(notice.NoticeID, true),
+			},
+		},
+	})
+}
+
+func updateLastNotice(noticeID string, overwrite bool) func(context.Context, *bridgev2.Portal) bool {
+	return func(ctx context.Context, portal *bridgev2.Portal) (changed bool) {
+		meta := portal.Metadata.(*qqid.PortalMetadata)
+		if meta.LastNoticeID != noticeID && (overwrite || meta.LastNoticeID == "") {
+			meta.LastNoticeID = noticeID
+			changed = true
+		}
+
+		return
+	}
+}
+
+func noticeText(notice *entity.GroupNoticeFeed) string {
+	return strings.TrimSpace(html.UnescapeString(notice.Message.Text))
+}
@@ -0,0 +1,372 @@
+package connector
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+func (qc *QQClient) FetchMessages(ctx context.Context, params bridgev2.FetchMessagesParams) (*bridgev2.FetchMessagesResponse, error) {
+	meta := params.Portal.Metadata.(*qqid.PortalMetadata)
+
+	var depth uint
+	switch meta.ChatType {
+	case qqid.ChatPrivate:
+		depth = qc.Main.Config.Backfill.PrivateDepth
+	case qqid.ChatGroup:
+		depth = qc.Main.Config.Backfill.GroupDepth
+	default:
+		return &bridgev2.FetchMessagesResponse{Forward: params.Forward}, nil
+	}
+
+	if params.Forward {
+		return qc.fetchForward(ctx, params, min(uint(max(params.Count, 1)), depth))
+	}
+
+	position, remaining, err := parseBackfillCursor(params.Cursor, depth)
+	if err != nil {
+		return nil, err
+	}
+	if remaining == 0 {
+		return &bridgev2.FetchMessagesResponse{}, nil
+	}
+
+	count := min(uint(max(params.Count, 1)), remaining)
+
+	var messages []*qqid.Message
+	switch meta.ChatType {
+	case qqid.ChatPrivate:
+		messages, position, err = qc.fetchPrivateHistory(params, position, count)
+	case qqid.ChatGroup:
+		messages, position, err = qc.fetchGroupHistory(params, position, count)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &bridgev2.FetchMessagesResponse{
+		HasMore: position > 0,
+	}
+	if resp.Messages, err = qc.convertHistory(ctx, params.Portal, messages); err != nil {
+		return nil, err
+	}
+
+	if remaining = remaining - min(remaining, uint(len(messages))); remaining == 0 {
+		resp.HasMore = false
+	}
+	if resp.HasMore {
+		resp.Cursor = makeBackfillCursor(position, remaining)
+	}
+
+	return resp, nil
+}
+
+// fetchForward fetches the latest messages after the anchor message, which fills
+// new portals and catches up on messages missed while disconnected.
+func (qc *QQClient) fetchForward(ctx context.Context, params bridgev2.FetchMessagesParams, count uint) (*bridgev2.FetchMessagesResponse, error) {
+	var messages []*qqid.Message
+	var err error
+
+	switch params.Portal.Metadata.(*qqid.PortalMetadata).ChatType {
+	case qqid.ChatPrivate:
+		messages, err = qc.fetchPrivateLatest(ctx, params, count)
+	case qqid.ChatGroup:
+		messages, err = qc.fetchGroupLatest(ctx, params, count)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &bridgev2.FetchMessagesResponse{
+		Forward: true,
+	}
+	if resp.Messages, err = qc.convertHistory(ctx, params.Portal, messages); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// convertHistory converts fetched messages, skipping those already bridged.
+func (qc *QQClient) convertHistory(ctx context.Context, portal *bridgev2.Portal, messages []*qqid.Message) ([]*bridgev2.BackfillMessage, error) {
+	converted := make([]*bridgev2.BackfillMessage, 0, len(messages))
+
+	for i, msg := range messages {
+		id := qqid.MakeMessageID(msg.ChatID, msg.ID)
+		if existing, err := qc.Main.Bridge.DB.Message.GetFirstPartByID(ctx, qc.UserLogin.ID, id); err != nil {
+			return nil, err
+		} else if existing != nil {
+			continue
+		}
+
+		sender := qc.makeEventSender(msg.SenderID)
+		intent := portal.GetIntentFor(ctx, sender, qc.UserLogin, bridgev2.RemoteEventBackfill)
+		ts := time.UnixMilli(msg.Timestamp)
+
+		converted = append(converted, &bridgev2.BackfillMessage{
+			ConvertedMessage: qc.Main.MsgConv.ToMatrix(ctx, qc.Client, portal, intent, msg),
+			Sender:           sender,
+			ID:               id,
+			Timestamp:        ts,
+			StreamOrder:      backfillStreamOrder(ts, i),
+		})
+	}
+
+	return converted, nil
+}
+
+// fetchPrivateHistory fetches messages sent before the timestamp in position.
+// It returns the messages oldest first and the timestamp to continue from.
+func (qc *QQClient) fetchPrivateHistory(params bridgev2.FetchMessagesParams, position uint32, count uint) ([]*qqid.Message, uint32, error) {
+	if position == 0 {
+		if params.AnchorMessage != nil {
+			position = uint32(params.AnchorMessage.Timestamp.Unix())
+		} else {
+			position = uint32(time.Now().Unix())
+		}
+	}
+
+	uin, err := strconv.ParseUint(string(params.Portal.ID), 10, 32)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	history, err := qc.Client.GetPrivateMessages(uint32(uin), position, uint32(count))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch private messages: %w", err)
+	}
+
+	slices.SortFunc(history, func(a, b *message.PrivateMessage) int {
+		return cmp.Or(cmp.Compare(a.Time, b.Time), cmp.Compare(a.ID, b.ID))
+	})
+
+	messages := make([]*qqid.Message, 0, len(history))
+	next := uint32(0)
+	for _, msg := range history {
+		if msg.Time > position || msg.Sender == nil {
+			continue
+		}
+		if next == 0 {
+			next = msg.Time
+		}
+		if len(msg.Elements) == 0 {
+			continue
+		}
+
+		messages = append(messages, &qqid.Message{
+			ID:        fmt.Sprint(msg.ID),
+			Timestamp: int64(msg.Time) * 1000,
+			Type:      qqid.ParseMessageType(msg.Elements),
+			ChatID:    string(params.Portal.ID),
+			ChatType:  qqid.ChatPrivate,
+			SenderID:  fmt.Sprint(msg.Sender.Uin),
+			Elements:  msg.Elements,
+		})
+	}
+
+	// Stop if the page didn't move us further back in time
+	if next >= position {
+		next = 0
+	}
+
+	return messages, next, nil
+}
+
+// fetchPrivateLatest fetches up to count of the latest private messages after the anchor message,
+// paging back until the anchor is reached.
+func (qc *QQClient) fetchPrivateLatest(ctx context.Context, params bridgev2.FetchMessagesParams, count uint) ([]*qqid.Message, error) {
+	var anchor time.Time
+	if params.AnchorMessage != nil {
+		anchor = params.AnchorMessage.Timestamp
+	}
+
+	var messages []*qqid.Message
+	seen := make(map[string]struct{})
+	position := uint32(time.Now().Unix())
+	for uint(len(messages)) < count {
+		page, next, err := qc.fetchPrivateHistory(params, position, count-uint(len(messages)))
+		if err != nil {
+			return nil, err
+		}
+
+		// Pages overlap on the second they start from
+		page = slices.DeleteFunc(page, func(msg *qqid.Message) bool {
+			_, duplicate := seen[msg.ID]
+			seen[msg.ID] = struct{}{}
+			return duplicate || time.UnixMilli(msg.Timestamp).Before(anchor)
+		})
+		messages = append(page, messages...)
+
+		if next == 0 || (!anchor.IsZero() && !time.Unix(int64(next), 0).After(anchor)) {
+			return messages, nil
+		}
+		position = next
+	}
+
+	if !anchor.IsZero() {
+		zerolog.Ctx(ctx).Warn().Uint("count", count).Msg("Catch-up limit reached before the last bridged message, older missed messages are skipped")
+	}
+	if uint(len(messages)) > count {
+		messages = messages[uint(len(messages))-count:]
+	}
+
+	return messages, nil
+}
+
+// fetchGroupHistory fetches messages with a sequence lower than position.
+// It returns the messages oldest first and the sequence to continue from.
+func (qc *QQClient) fetchGroupHistory(params bridgev2.FetchMessagesParams, position uint32, count uint) ([]*qqid.Message, uint32, error) {
+	uin, err := strconv.ParseUint(string(params.Portal.ID), 10, 32)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if position == 0 {
+		position = anchorSequence(params.AnchorMessage)
+		if position == 0 {
+			last, err := qc.getLastGroupSequence(uint32(uin))
+			if err != nil {
+				return nil, 0, err
+			}
+			// Include the latest message
+			position = last + 1
+		}
+	}
+
+	if position <= 1 {
+		return nil, 0, nil
+	}
+
+	end := position - 1
+	start := uint32(1)
+	if uint(end) > count {
+		start = end - uint32(count) + 1
+	}
+
+	history, err := qc.Client.GetGroupMessages(uint32(uin), start, end)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch group messages: %w", err)
+	}
+
+	slices.SortFunc(history, func(a, b *message.GroupMessage) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	messages := make([]*qqid.Message, 0, len(history))
+	for _, msg := range history {
+		if len(msg.Elements) == 0 || msg.Sender == nil {
+			continue
+		}
+
+		messages = append(messages, &qqid.Message{
+			ID:        fmt.Sprint(msg.ID),
+			Timestamp: int64(msg.Time) * 1000,
+			Type:      qqid.ParseMessageType(msg.Elements),
+			ChatID:    string(params.Portal.ID),
+			ChatType:  qqid.ChatGroup,
+			SenderID:  fmt.Sprint(msg.Sender.Uin),
+			Elements:  msg.Elements,
+		})
+	}
+
+	// Sequences of recalled or deleted messages are simply missing from the result,
+	// so continue from the requested range rather than the returned messages.
+	if start <= 1 {
+		start = 0
+	}
+
+	return messages, start, nil
+}
+
+// fetchGroupLatest fetches up to count of the latest group messages after the anchor message.
+func (qc *QQClient) fetchGroupLatest(ctx context.Context, params bridgev2.FetchMessagesParams, count uint) ([]*qqid.Message, error) {
+	uin, err := strconv.ParseUint(string(params.Portal.ID), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := qc.getLastGroupSequence(uint32(uin))
+	if err != nil {
+		return nil, err
+	}
+
+	if anchor := anchorSequence(params.AnchorMessage); anchor != 0 {
+		if anchor >= last {
+			return nil, nil
+		}
+		if uint(last-anchor) > count {
+			zerolog.Ctx(ctx).Warn().Uint("count", count).Uint32("missed", last-anchor).Msg("Catch-up limit reached before the last bridged message, older missed messages are skipped")
+		}
+		count = min(count, uint(last-anchor))
+	}
+
+	messages, _, err := qc.fetchGroupHistory(params, last+1, count)
+	return messages, err
+}
+
+func (qc *QQClient) getLastGroupSequence(groupUin uint32) (uint32, error) {
+	info, err := qc.Client.FetchGroupInfo(groupUin, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch group info: %w", err)
+	}
+	return info.LastMsgSeq, nil
+}
+
+// anchorSequence returns the group message sequence of the anchor message, or 0 if there is none.
+func anchorSequence(anchor *database.Message) uint32 {
+	if anchor == nil {
+		return 0
+	}
+	parsed, err := qqid.ParseMessageID(anchor.ID)
+	if err != nil {
+		return 0
+	}
+	seq, err := strconv.ParseUint(parsed.ID, 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(seq)
+}
+
+// backfillStreamOrder orders the messages of a batch. QQ times only have second precision,
+// so the index in the batch keeps messages sent in the same second in order.
+func backfillStreamOrder(ts time.Time, index int) int64 {
+	return ts.UnixMilli() + int64(index)
+}
+
+func makeBackfillCursor(position uint32, remaining uint) networkid.PaginationCursor {
+	return networkid.PaginationCursor(fmt.Sprintf("%d:%d", position, remaining))
+}
+
+func parseBackfillCursor(cursor networkid.PaginationCursor, depth uint) (uint32, uint, error) {
+	if cursor == "" {
+		return 0, depth, nil
+	}
+
+	parts := strings.SplitN(string(cursor), ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid backfill cursor %q", cursor)
+	}
+	position, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid backfill cursor %q: %w", cursor, err)
+	}
+	remaining, err := strconv.ParseUint(parts[1], 10, 0)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid backfill cursor %q: %w", cursor, err)
+	}
+
+	return uint32(position), min(uint(remaining), depth), nil
+}
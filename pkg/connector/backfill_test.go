@@ -0,0 +1,87 @@
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+)
+
+func TestParseBackfillCursor(t *testing.T) {
+	tests := []struct {
+		cursor    networkid.PaginationCursor
+		depth     uint
+		position  uint32
+		remaining uint
+		wantErr   bool
+	}{
+		{"", 50, 0, 50, false},
+		{"1700000000:30", 50, 1700000000, 30, false},
+		{"120:80", 50, 120, 50, false},
+		{"120:0", 50, 120, 0, false},
+		{"120", 50, 0, 0, true},
+		{"abc:10", 50, 0, 0, true},
+		{"120:-1", 50, 0, 0, true},
+		{"4294967296:10", 50, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		position, remaining, err := parseBackfillCursor(tt.cursor, tt.depth)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBackfillCursor(%q) error = %v, want error %v", tt.cursor, err, tt.wantErr)
+			continue
+		}
+		if position != tt.position || remaining != tt.remaining {
+			t.Errorf("parseBackfillCursor(%q, %d) = %d, %d, want %d, %d", tt.cursor, tt.depth, position, remaining, tt.position, tt.remaining)
+		}
+	}
+}
+
+func TestBackfillCursorRoundTrip(t *testing.T) {
+	cursor := makeBackfillCursor(4294967295, 42)
+	position, remaining, err := parseBackfillCursor(cursor, 100)
+	if err != nil {
+		t.Fatalf("parseBackfillCursor(%q) failed: %v", cursor, err)
+	}
+	if position != 4294967295 || remaining != 42 {
+		t.Errorf("parseBackfillCursor(%q) = %d, %d, want 4294967295, 42", cursor, position, remaining)
+	}
+}
+
+func TestAnchorSequence(t *testing.T) {
+	tests := []struct {
+		anchor *database.Message
+		want   uint32
+	}{
+		{nil, 0},
+		{&database.Message{ID: qqid.MakeMessageID("123", "456")}, 456},
+		{&database.Message{ID: qqid.MakeFakeMessageID("123", "poke")}, 0},
+		{&database.Message{ID: qqid.MakeMessageID("123", "abc")}, 0},
+		{&database.Message{ID: "invalid"}, 0},
+	}
+
+	for _, tt := range tests {
+		if got := anchorSequence(tt.anchor); got != tt.want {
+			t.Errorf("anchorSequence(%v) = %d, want %d", tt.anchor, got, tt.want)
+		}
+	}
+}
+
+func TestBackfillStreamOrder(t *testing.T) {
+	second := time.Unix(1700000000, 0)
+	orders := []int64{
+		backfillStreamOrder(second, 0),
+		backfillStreamOrder(second, 1),
+		backfillStreamOrder(second, 2),
+		backfillStreamOrder(second.Add(time.Second), 3),
+	}
+
+	for i := 1; i < len(orders); i++ {
+		if orders[i] <= orders[i-1] {
+			t.Errorf("stream order %d (%d) is not after %d (%d)", i, orders[i], i-1, orders[i-1])
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"time"
 
+	"github.com/duo/matrix-qq/pkg/qqid"
+
 	"go.mau.fi/util/ffmpeg"
 	"go.mau.fi/util/jsontime"
 	"go.mau.fi/util/ptr"
@@ -14,6 +16,7 @@ import (
 const MaxTextLength = 4096
 const MaxFileSize = 128 * 1024 * 1024
 const MaxImageSize = 128 * 1024 * 1024
+const MaxRecallAge = 2 * time.Minute
 
 func supportedIfFFmpeg() event.CapabilitySupportLevel {
 	if ffmpeg.Supported() {
@@ -111,10 +114,20 @@ var qqCaps = &event.RoomFeatures{
 	Reply:           event.CapLevelFullySupported,
 	Delete:          event.CapLevelFullySupported,
 	DeleteForMe:     false,
-	DeleteMaxAge:    ptr.Ptr(jsontime.S(2 * time.Minute)),
+	DeleteMaxAge:    ptr.Ptr(jsontime.S(MaxRecallAge)),
 }
 
+var qqGroupCaps = func() *event.RoomFeatures {
+	caps := *qqCaps
+	caps.ID = qqCaps.ID + "+group"
+	caps.Reaction = event.CapLevelFullySupported
+	return &caps
+}()
+
 func (qc *QQClient) GetCapabilities(ctx context.Context, portal *bridgev2.Portal) *event.RoomFeatures {
+	if portal.Metadata.(*qqid.PortalMetadata).ChatType == qqid.ChatGroup {
+		return qqGroupCaps
+	}
 	return qqCaps
 }
 
@@ -7,6 +7,7 @@ import (
 	"math/rand/v2"
 	"net/url"
 	"path"
+	"slices"
 	"strconv"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 const (
 	PrivateChatTopic = "QQ private chat"
 
+	powerMuted      = -1
 	powerDefault    = 0
 	powerAdmin      = 50
 	powerSuperAdmin = 75
@@ -108,7 +110,7 @@ func (qc *QQClient) getDirectChatInfo(recipient string) (*bridgev2.ChatInfo, err
 	}, nil
 }
 
-func (qc *QQClient) getGroupChatInfo(_ context.Context, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
+func (qc *QQClient) getGroupChatInfo(ctx context.Context, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
 	uin, _ := strconv.ParseUint(string(portal.ID), 10, 32)
 
 	groupInfo := qc.Client.GetCachedGroupInfo(uint32(uin))
@@ -117,6 +119,11 @@ func (qc *QQClient) getGroupChatInfo(_ context.Context, portal *bridgev2.Portal)
 		return nil, fmt.Errorf("failed to fetch group info")
 	}
 
+	eventsDefault := powerDefault
+	if portal.Metadata.(*qqid.PortalMetadata).MuteAll {
+		eventsDefault = powerAdmin
+	}
+
 	wrapped := &bridgev2.ChatInfo{
 		Name:   ptr.Ptr(groupInfo.GroupName),
 		Avatar: wrapAvatar(qqid.GetGroupAvatarURL(groupInfo.GroupUin)),
@@ -126,13 +133,13 @@ func (qc *QQClient) getGroupChatInfo(_ context.Context, portal *bridgev2.Portal)
 			MemberMap:        make(map[networkid.UserID]bridgev2.ChatMember, len(membersInfo)),
 			PowerLevels: &bridgev2.PowerLevelOverrides{
 				Events: map[event.Type]int{
-					event.StateRoomName:   powerDefault,
-					event.StateRoomAvatar: powerDefault,
-					event.StateTopic:      powerDefault,
+					event.StateRoomName:   powerAdmin,
+					event.StateRoomAvatar: powerAdmin,
+					event.StateTopic:      powerAdmin,
 					event.EventReaction:   powerDefault,
 					event.EventRedaction:  powerDefault,
 				},
-				EventsDefault: ptr.Ptr(powerDefault),
+				EventsDefault: ptr.Ptr(eventsDefault),
 				StateDefault:  ptr.Ptr(powerAdmin),
 			},
 		},
@@ -141,13 +148,20 @@ func (qc *QQClient) getGroupChatInfo(_ context.Context, portal *bridgev2.Portal)
 		ExtraUpdates: updateChatType(qqid.ChatGroup),
 	}
 
+	if notice := qc.getTopicNotice(ctx, portal, groupInfo.GroupUin); notice != nil {
+		wrapped.Topic = ptr.Ptr(noticeText(notice))
+		wrapped.ExtraUpdates = bridgev2.MergeExtraUpdaters(wrapped.ExtraUpdates, updateLastNotice(notice.NoticeID, false))
+	}
+
 	for _, m := range membersInfo {
+		if qc.isDepartedMember(groupInfo.GroupUin, m.Uin) {
+			continue
+		}
+
 		evtSender := qc.makeEventSender(fmt.Sprint(m.Uin))
-		pl := powerDefault
-		if m.Permission == entity.Owner {
-			pl = powerSuperAdmin
-		} else if m.Permission == entity.Admin {
-			pl = powerAdmin
+		pl := permissionToPowerLevel(m.Permission)
+		if pl == powerDefault && muteRemaining(m.ShutUpTime, time.Now()) > 0 {
+			pl = powerMuted
 		}
 
 		wrapped.Members.MemberMap[evtSender.Sender] = bridgev2.ChatMember{
@@ -323,40 +337,166 @@ func (qc *QQClient) doGhostResync(ctx context.Context, queue map[string]resyncQu
 	}
 }
 
-func (qc *QQClient) updateMemberDisplyname(ctx context.Context, portal *bridgev2.Portal) bool {
-	groupID, _ := strconv.ParseUint(string(portal.ID), 10, 32)
-	if members := qc.Client.GetCachedMembersInfo(uint32(groupID)); members != nil {
-		for _, member := range members {
-			memberIntent := portal.GetIntentFor(ctx, qc.makeEventSender(fmt.Sprint(member.Uin)), qc.UserLogin, bridgev2.RemoteEventChatInfoChange)
+type startupChat struct {
+	chatType     qqid.ChatType
+	chatID       string
+	lastActivity time.Time
+}
 
-			mxid := memberIntent.GetMXID()
+func (qc *QQClient) syncChats(ctx context.Context) {
+	cfg := qc.Main.Config.StartupSync
+	log := qc.UserLogin.Log.With().Str("action", "startup sync").Logger()
 
-			memberInfo, err := portal.Bridge.Matrix.GetMemberInfo(ctx, portal.MXID, mxid)
-			if err != nil {
-				zerolog.Ctx(ctx).Err(err).Msg("Failed to get member info")
+	var chats []startupChat
+	for uin := range qc.Client.GetCachedAllGroupsInfo() {
+		// Groups have no activity timestamp, use the latest message of any member
+		var lastMsgTime uint32
+		for _, m := range qc.Client.GetCachedMembersInfo(uin) {
+			lastMsgTime = max(lastMsgTime, m.LastMsgTime)
+		}
+
+		chat := startupChat{chatType: qqid.ChatGroup, chatID: fmt.Sprint(uin)}
+		if lastMsgTime > 0 {
+			chat.lastActivity = time.Unix(int64(lastMsgTime), 0)
+		}
+		chats = append(chats, chat)
+	}
+
+	if cfg.Friends {
+		for uin := range qc.Client.GetCachedAllFriendsInfo() {
+			if qqid.MakeUserLoginID(fmt.Sprint(uin)) == qc.UserLogin.ID {
 				continue
 			}
+			chats = append(chats, startupChat{chatType: qqid.ChatPrivate, chatID: fmt.Sprint(uin)})
+		}
+	}
 
-			displayName := cmp.Or(member.Remarks, member.MemberCard, member.Nickname)
-			if memberInfo.Displayname != displayName {
-				memberInfo.Displayname = displayName
+	chats = filterStartupChats(chats, cfg.IncludeGroups, cfg.ExcludeGroups, cfg.SortByActivity, cfg.Limit)
 
-				var zeroTime time.Time
-				_, err = memberIntent.SendState(ctx, portal.MXID, event.StateMember, mxid.String(), &event.Content{
-					Parsed: memberInfo,
-				}, zeroTime)
+	log.Info().Int("count", len(chats)).Msg("Syncing chats after login")
 
-				if err != nil {
-					zerolog.Ctx(ctx).Err(err).Stringer("user_id", mxid).Msg("Failed to update group displayname")
+	for _, chat := range chats {
+		if ctx.Err() != nil {
+			return
+		}
+
+		qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.ChatResync{
+			EventMeta: simplevent.EventMeta{
+				Type: bridgev2.RemoteEventChatResync,
+				LogContext: func(c zerolog.Context) zerolog.Context {
+					return c.Str("sync_reason", "startup")
+				},
+				PortalKey:    qc.makePortalKey(chat.chatType, chat.chatID),
+				CreatePortal: true,
+			},
+			GetChatInfoFunc: func(ctx context.Context, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
+				if chat.chatType == qqid.ChatGroup {
+					return qc.getGroupChatInfo(ctx, portal)
 				}
-				zerolog.Ctx(ctx).Debug().Stringer("user_id", mxid).Msgf("Update group displayname to %s", displayName)
-			}
+				return qc.getDirectChatInfo(chat.chatID)
+			},
+			LatestMessageTS: chat.lastActivity,
+		})
+	}
+}
+
+// filterStartupChats applies the group include and exclude lists, then keeps up to limit chats,
+// the most recently active first if sortByActivity is set.
+func filterStartupChats(chats []startupChat, include, exclude []uint32, sortByActivity bool, limit int) []startupChat {
+	chats = slices.DeleteFunc(chats, func(chat startupChat) bool {
+		if chat.chatType != qqid.ChatGroup {
+			return false
+		}
+		uin, err := strconv.ParseUint(chat.chatID, 10, 32)
+		if err != nil {
+			return true
+		}
+		if slices.Contains(exclude, uint32(uin)) {
+			return true
 		}
+		return len(include) > 0 && !slices.Contains(include, uint32(uin))
+	})
+
+	if sortByActivity {
+		slices.SortStableFunc(chats, func(a, b startupChat) int {
+			return b.lastActivity.Compare(a.lastActivity)
+		})
+	}
+	if limit > 0 && len(chats) > limit {
+		chats = chats[:limit]
+	}
+
+	return chats
+}
+
+func (qc *QQClient) updateMemberDisplyname(ctx context.Context, portal *bridgev2.Portal) bool {
+	groupID, _ := strconv.ParseUint(string(portal.ID), 10, 32)
+	members := qc.Client.GetCachedMembersInfo(uint32(groupID))
+	if members == nil {
+		return false
+	}
+
+	// Fetch the whole member list once instead of querying every member
+	current, err := portal.Bridge.Matrix.GetMembers(ctx, portal.MXID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get room members")
+		return false
+	}
+
+	for _, member := range members {
+		memberIntent := portal.GetIntentFor(ctx, qc.makeEventSender(fmt.Sprint(member.Uin)), qc.UserLogin, bridgev2.RemoteEventChatInfoChange)
+		qc.setMemberDisplayname(ctx, portal, memberIntent, member, current[memberIntent.GetMXID()])
 	}
 
 	return false
 }
 
+func (qc *QQClient) updateSingleMemberDisplayname(ctx context.Context, portal *bridgev2.Portal, groupUin, uin uint32) {
+	member := qc.Client.GetCachedMemberInfo(uin, groupUin)
+	if member == nil {
+		return
+	}
+
+	memberIntent := portal.GetIntentFor(ctx, qc.makeEventSender(fmt.Sprint(uin)), qc.UserLogin, bridgev2.RemoteEventChatInfoChange)
+	memberInfo, err := portal.Bridge.Matrix.GetMemberInfo(ctx, portal.MXID, memberIntent.GetMXID())
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get member info")
+		return
+	}
+
+	qc.setMemberDisplayname(ctx, portal, memberIntent, member, memberInfo)
+}
+
+func (qc *QQClient) setMemberDisplayname(
+	ctx context.Context,
+	portal *bridgev2.Portal,
+	memberIntent bridgev2.MatrixAPI,
+	member *entity.GroupMember,
+	memberInfo *event.MemberEventContent,
+) {
+	if memberInfo == nil || memberInfo.Membership != event.MembershipJoin {
+		return
+	}
+
+	displayName := cmp.Or(member.Remarks, member.MemberCard, member.Nickname)
+	if memberInfo.Displayname == displayName {
+		return
+	}
+
+	mxid := memberIntent.GetMXID()
+	content := *memberInfo
+	content.Displayname = displayName
+
+	var zeroTime time.Time
+	if _, err := memberIntent.SendState(ctx, portal.MXID, event.StateMember, mxid.String(), &event.Content{
+		Parsed: &content,
+	}, zeroTime); err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("user_id", mxid).Msg("Failed to update group displayname")
+		return
+	}
+	zerolog.Ctx(ctx).Debug().Stringer("user_id", mxid).Msgf("Update group displayname to %s", displayName)
+}
+
 func updateChatType(chatType qqid.ChatType) func(context.Context, *bridgev2.Portal) bool {
 	return func(ctx context.Context, portal *bridgev2.Portal) (changed bool) {
 		meta := portal.Metadata.(*qqid.PortalMetadata)
@@ -369,6 +509,29 @@ func updateChatType(chatType qqid.ChatType) func(context.Context, *bridgev2.Port
 	}
 }
 
+func updateMuteAll(muteAll bool) func(context.Context, *bridgev2.Portal) bool {
+	return func(ctx context.Context, portal *bridgev2.Portal) (changed bool) {
+		meta := portal.Metadata.(*qqid.PortalMetadata)
+		if meta.MuteAll != muteAll {
+			meta.MuteAll = muteAll
+			changed = true
+		}
+
+		return
+	}
+}
+
+func permissionToPowerLevel(permission entity.GroupMemberPermission) int {
+	switch permission {
+	case entity.Owner:
+		return powerSuperAdmin
+	case entity.Admin:
+		return powerAdmin
+	default:
+		return powerDefault
+	}
+}
+
 func updateGhostLastSyncAt(ctx context.Context, ghost *bridgev2.Ghost) bool {
 	meta := ghost.Metadata.(*qqid.GhostMetadata)
 	forceSave := time.Since(meta.LastSync.Time) > 24*time.Hour
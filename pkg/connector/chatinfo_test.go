@@ -0,0 +1,56 @@
+package connector
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+)
+
+func TestFilterStartupChats(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	chats := []startupChat{
+		{chatType: qqid.ChatGroup, chatID: "1", lastActivity: base.Add(1 * time.Hour)},
+		{chatType: qqid.ChatGroup, chatID: "2", lastActivity: base.Add(3 * time.Hour)},
+		{chatType: qqid.ChatGroup, chatID: "3"},
+		{chatType: qqid.ChatPrivate, chatID: "1", lastActivity: base.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name           string
+		include        []uint32
+		exclude        []uint32
+		sortByActivity bool
+		limit          int
+		want           string
+	}{
+		{"all", nil, nil, false, 0, "g1 g2 g3 p1"},
+		{"include", []uint32{2, 3}, nil, false, 0, "g2 g3 p1"},
+		{"exclude", nil, []uint32{1}, false, 0, "g2 g3 p1"},
+		{"exclude wins", []uint32{1, 2}, []uint32{1}, false, 0, "g2 p1"},
+		{"sorted", nil, nil, true, 0, "g2 p1 g1 g3"},
+		{"limit", nil, nil, false, 2, "g1 g2"},
+		{"sorted limit", nil, nil, true, 2, "g2 p1"},
+		{"limit above count", nil, nil, false, 10, "g1 g2 g3 p1"},
+	}
+
+	for _, tt := range tests {
+		got := filterStartupChats(append([]startupChat(nil), chats...), tt.include, tt.exclude, tt.sortByActivity, tt.limit)
+		if desc := describeStartupChats(got); desc != tt.want {
+			t.Errorf("%s: filterStartupChats() = %q, want %q", tt.name, desc, tt.want)
+		}
+	}
+}
+
+func describeStartupChats(chats []startupChat) string {
+	parts := make([]string, 0, len(chats))
+	for _, chat := range chats {
+		prefix := "p"
+		if chat.chatType == qqid.ChatGroup {
+			prefix = "g"
+		}
+		parts = append(parts, prefix+chat.chatID)
+	}
+	return strings.Join(parts, " ")
+}
@@ -27,11 +27,31 @@ type QQClient struct {
 	resyncQueue     map[string]resyncQueueItem
 	resyncQueueLock sync.Mutex
 	nextResync      time.Time
+
+	muteTimers     map[string]*time.Timer
+	muteTimersLock sync.Mutex
+
+	// Members that left a group since its member cache was refreshed, see handleGroupMemberLeave
+	departedMembers sync.Map
+	memberRefreshes sync.Map
+
+	seenCards          sync.Map
+	friendRequestsLock sync.Mutex
+	// Latest announcement of every group that was checked, nil if there is none
+	latestNotices sync.Map
 }
 
 var (
 	_ bridgev2.NetworkAPI                    = (*QQClient)(nil)
 	_ bridgev2.IdentifierResolvingNetworkAPI = (*QQClient)(nil)
+	_ bridgev2.RedactionHandlingNetworkAPI   = (*QQClient)(nil)
+	_ bridgev2.ReactionHandlingNetworkAPI    = (*QQClient)(nil)
+	_ bridgev2.BackfillingNetworkAPI         = (*QQClient)(nil)
+	_ bridgev2.PowerLevelHandlingNetworkAPI  = (*QQClient)(nil)
+	_ bridgev2.MembershipHandlingNetworkAPI  = (*QQClient)(nil)
+	_ bridgev2.RoomNameHandlingNetworkAPI    = (*QQClient)(nil)
+	_ bridgev2.RoomAvatarHandlingNetworkAPI  = (*QQClient)(nil)
+	_ bridgev2.RoomTopicHandlingNetworkAPI   = (*QQClient)(nil)
 )
 
 func (qc *QQClient) Connect(ctx context.Context) {
@@ -48,6 +68,18 @@ func (qc *QQClient) Connect(ctx context.Context) {
 	qc.Client.GroupMessageEvent.Subscribe(qc.handleGroupMessage)
 	qc.Client.FriendRecallEvent.Subscribe(qc.handleFriendRecall)
 	qc.Client.GroupRecallEvent.Subscribe(qc.handleGroupRecall)
+	qc.Client.GroupReactionEvent.Subscribe(qc.handleGroupReaction)
+	qc.Client.GroupJoinEvent.Subscribe(qc.handleGroupJoin)
+	qc.Client.GroupInvitedEvent.Subscribe(qc.handleGroupInvited)
+	qc.Client.GroupLeaveEvent.Subscribe(qc.handleGroupLeave)
+	qc.Client.GroupMemberJoinEvent.Subscribe(qc.handleGroupMemberJoin)
+	qc.Client.GroupMemberLeaveEvent.Subscribe(qc.handleGroupMemberLeave)
+	qc.Client.GroupMuteEvent.Subscribe(qc.handleGroupMute)
+	qc.Client.NewFriendRequestEvent.Subscribe(qc.handleNewFriendRequest)
+	qc.Client.NewFriendEvent.Subscribe(qc.handleNewFriend)
+	qc.Client.GroupMemberJoinRequestEvent.Subscribe(qc.handleGroupMemberJoinRequest)
+	qc.Client.FriendNotifyEvent.Subscribe(qc.handleFriendPoke)
+	qc.Client.GroupNotifyEvent.Subscribe(qc.handleGroupPoke)
 
 	qc.Client.RefreshFriendCache()
 	qc.Client.RefreshAllGroupsInfo()
@@ -62,6 +94,13 @@ func (qc *QQClient) Disconnect() {
 		(*stopSyncLoop)()
 	}
 
+	qc.muteTimersLock.Lock()
+	for key, timer := range qc.muteTimers {
+		timer.Stop()
+		delete(qc.muteTimers, key)
+	}
+	qc.muteTimersLock.Unlock()
+
 	if cli := qc.Client; cli != nil {
 		cli.Release()
 		qc.Client = nil
@@ -91,4 +130,17 @@ func (qc *QQClient) startLoops() {
 	}
 
 	go qc.ghostResyncLoop(ctx)
+
+	// Mutes that started while the bridge was offline are lifted on time
+	for uin := range qc.Client.GetCachedAllGroupsInfo() {
+		qc.scheduleMutedMembers(uin)
+	}
+
+	if qc.Main.Config.StartupSync.Enabled {
+		go qc.syncChats(ctx)
+	}
+
+	if qc.Main.Config.Announcements.PollInterval > 0 {
+		go qc.announcementLoop(ctx)
+	}
 }
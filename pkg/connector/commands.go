@@ -0,0 +1,311 @@
+package connector
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"maunium.net/go/mautrix/bridgev2/commands"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/id"
+)
+
+var (
+	HelpSectionRequests = commands.HelpSection{Name: "Friend and group requests", Order: 25}
+	HelpSectionActions  = commands.HelpSection{Name: "Chat actions", Order: 30}
+)
+
+var cmdAcceptFriend = &commands.FullHandler{
+	Func: fnFriendRequest(true),
+	Name: "accept-friend",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionRequests,
+		Description: "Accept a pending QQ friend request",
+		Args:        "<_uin_>",
+	},
+	RequiresLogin: true,
+}
+
+var cmdRejectFriend = &commands.FullHandler{
+	Func: fnFriendRequest(false),
+	Name: "reject-friend",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionRequests,
+		Description: "Reject a pending QQ friend request",
+		Args:        "<_uin_>",
+	},
+	RequiresLogin: true,
+}
+
+var cmdApprove = &commands.FullHandler{
+	Func: fnJoinRequest(true),
+	Name: "approve",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionRequests,
+		Description: "Approve a pending request to join this group",
+		Args:        "<_id_>",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+var cmdReject = &commands.FullHandler{
+	Func: fnJoinRequest(false),
+	Name: "reject",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionRequests,
+		Description: "Reject a pending request to join this group",
+		Args:        "<_id_> [_reason_]",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+var cmdPoke = &commands.FullHandler{
+	Func: fnPoke,
+	Name: "poke",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionActions,
+		Description: "Poke a user in this chat. The user is optional in private chats",
+		Args:        "[_uin or Matrix user ID_]",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+var cmdForward = &commands.FullHandler{
+	Func: fnForward,
+	Name: "forward",
+	Help: commands.HelpMeta{
+		Section:     HelpSectionActions,
+		Description: "Send the last messages, or the messages between two events, as a merged forward to another bridged room or this chat",
+		Args:        "[_room ID_] <_count_ | _first event ID_ [_last event ID_]>",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func (qc *QQConnector) registerCommands() {
+	if proc, ok := qc.Bridge.Commands.(*commands.Processor); ok {
+		proc.AddHandlers(
+			cmdAcceptFriend,
+			cmdRejectFriend,
+			cmdApprove,
+			cmdReject,
+			cmdPoke,
+			cmdForward,
+		)
+	}
+}
+
+func fnFriendRequest(accept bool) func(*commands.Event) {
+	return func(ce *commands.Event) {
+		if len(ce.Args) != 1 {
+			ce.Reply("Usage: `$cmdprefix %s <uin>`", ce.Command)
+			return
+		}
+		uin, err := strconv.ParseUint(ce.Args[0], 10, 32)
+		if err != nil {
+			ce.Reply("Invalid UIN %q", ce.Args[0])
+			return
+		}
+
+		// Find the login that received the request
+		for _, login := range ce.User.GetUserLogins() {
+			qc, ok := login.Client.(*QQClient)
+			if !ok || !qc.IsLoggedIn() {
+				continue
+			}
+			if found, err := qc.answerFriendRequest(ce.Ctx, uint32(uin), accept); err != nil {
+				ce.Reply("Failed to answer friend request: %v", err)
+				return
+			} else if found {
+				if accept {
+					ce.Reply("Accepted friend request from %d", uin)
+				} else {
+					ce.Reply("Rejected friend request from %d", uin)
+				}
+				return
+			}
+		}
+
+		ce.Reply("No pending friend request from %d", uin)
+	}
+}
+
+func fnJoinRequest(accept bool) func(*commands.Event) {
+	return func(ce *commands.Event) {
+		if len(ce.Args) < 1 || (accept && len(ce.Args) > 1) {
+			if accept {
+				ce.Reply("Usage: `$cmdprefix %s <id>`", ce.Command)
+			} else {
+				ce.Reply("Usage: `$cmdprefix %s <id> [reason]`", ce.Command)
+			}
+			return
+		}
+		seq, err := strconv.ParseUint(ce.Args[0], 10, 64)
+		if err != nil {
+			ce.Reply("Invalid request ID %q", ce.Args[0])
+			return
+		}
+		reason := strings.Join(ce.Args[1:], " ")
+
+		if ce.Portal.Metadata.(*qqid.PortalMetadata).ChatType != qqid.ChatGroup {
+			ce.Reply("This command can only be used in group portals")
+			return
+		}
+		groupUin, _ := strconv.ParseUint(string(ce.Portal.ID), 10, 32)
+
+		// Only logins that administer the group may answer
+		for _, login := range ce.User.GetUserLogins() {
+			qc, ok := login.Client.(*QQClient)
+			if !ok || !qc.IsLoggedIn() || !qc.isGroupAdmin(uint32(groupUin)) {
+				continue
+			}
+			if found, err := qc.answerJoinRequest(uint32(groupUin), seq, accept, reason); err != nil {
+				ce.Reply("Failed to answer join request: %v", err)
+				return
+			} else if found {
+				if accept {
+					ce.Reply("Approved join request %d", seq)
+				} else {
+					ce.Reply("Rejected join request %d", seq)
+				}
+				return
+			}
+			ce.Reply("No pending join request with ID %d", seq)
+			return
+		}
+
+		ce.Reply("You are not an admin of this group")
+	}
+}
+
+func fnPoke(ce *commands.Event) {
+	if len(ce.Args) > 1 {
+		ce.Reply("Usage: `$cmdprefix poke [uin or Matrix user ID]`")
+		return
+	}
+
+	var uin uint64
+	if len(ce.Args) == 1 {
+		target := ce.Args[0]
+		// Accept pills and Matrix user IDs of ghosts
+		if strings.HasPrefix(target, "@") {
+			if ghostID, ok := ce.Bridge.Matrix.ParseGhostMXID(id.UserID(target)); ok {
+				target = string(ghostID)
+			}
+		}
+		var err error
+		if uin, err = strconv.ParseUint(target, 10, 32); err != nil {
+			ce.Reply("Invalid user %q", ce.Args[0])
+			return
+		}
+	}
+
+	login, _, err := ce.Portal.FindPreferredLogin(ce.Ctx, ce.User, false)
+	if err != nil || login == nil {
+		ce.Reply("You are not logged in to this chat")
+		return
+	}
+	qc, ok := login.Client.(*QQClient)
+	if !ok || !qc.IsLoggedIn() {
+		ce.Reply("You are not logged in to this chat")
+		return
+	}
+
+	if err := qc.sendPoke(ce.Portal, uint32(uin)); err != nil {
+		ce.Reply("Failed to poke: %v", err)
+		return
+	}
+	ce.React("✅")
+}
+
+// Maximum number of messages in a merged forward sent by the forward command
+const maxForwardMessages = 100
+
+func fnForward(ce *commands.Event) {
+	args := ce.Args
+	// The forward goes to this chat unless another bridged room is given
+	target := ce.Portal
+	if len(args) > 0 && strings.HasPrefix(args[0], "!") {
+		portal, err := ce.Bridge.GetPortalByMXID(ce.Ctx, id.RoomID(args[0]))
+		if err != nil {
+			ce.Reply("Failed to get room: %v", err)
+			return
+		} else if portal == nil {
+			ce.Reply("%s is not a bridged room", args[0])
+			return
+		}
+		target = portal
+		args = args[1:]
+	}
+	if len(args) < 1 || len(args) > 2 {
+		ce.Reply("Usage: `$cmdprefix forward [room ID] <count | first event ID [last event ID]>`")
+		return
+	}
+
+	login, _, err := target.FindPreferredLogin(ce.Ctx, ce.User, false)
+	if err != nil || login == nil {
+		ce.Reply("You are not logged in to the target chat")
+		return
+	}
+	qc, ok := login.Client.(*QQClient)
+	if !ok || !qc.IsLoggedIn() {
+		ce.Reply("You are not logged in to the target chat")
+		return
+	}
+
+	var messages []*database.Message
+	if count, err := strconv.Atoi(args[0]); err == nil {
+		if count <= 0 || count > maxForwardMessages {
+			ce.Reply("The count must be between 1 and %d", maxForwardMessages)
+			return
+		}
+		if messages, err = ce.Bridge.DB.Message.GetLastNInPortal(ce.Ctx, ce.Portal.PortalKey, count); err != nil {
+			ce.Reply("Failed to get messages: %v", err)
+			return
+		}
+		slices.Reverse(messages)
+	} else {
+		first, err := ce.Bridge.DB.Message.GetPartByMXID(ce.Ctx, id.EventID(args[0]))
+		if err != nil || first == nil || first.Room != ce.Portal.PortalKey {
+			ce.Reply("Unknown event %s", args[0])
+			return
+		}
+		last := first
+		if len(args) == 2 {
+			last, err = ce.Bridge.DB.Message.GetPartByMXID(ce.Ctx, id.EventID(args[1]))
+			if err != nil || last == nil || last.Room != ce.Portal.PortalKey {
+				ce.Reply("Unknown event %s", args[1])
+				return
+			}
+		}
+		if last.Timestamp.Before(first.Timestamp) {
+			first, last = last, first
+		}
+		// The start of the time range is exclusive
+		if messages, err = ce.Bridge.DB.Message.GetMessagesBetweenTimeQuery(ce.Ctx, ce.Portal.PortalKey, first.Timestamp.Add(-1), last.Timestamp); err != nil {
+			ce.Reply("Failed to get messages: %v", err)
+			return
+		}
+		slices.SortStableFunc(messages, func(a, b *database.Message) int {
+			if c := a.Timestamp.Compare(b.Timestamp); c != 0 {
+				return c
+			}
+			return strings.Compare(string(a.PartID), string(b.PartID))
+		})
+		if len(messages) > maxForwardMessages {
+			ce.Reply("Can't forward more than %d messages at once", maxForwardMessages)
+			return
+		}
+	}
+
+	if err := qc.sendMergedForward(ce.Ctx, ce.Portal, target, messages); err != nil {
+		ce.Reply("Failed to send merged forward: %v", err)
+		return
+	}
+	ce.React("✅")
+}
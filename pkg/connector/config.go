@@ -20,6 +20,43 @@ type Config struct {
 
 	SignServers []string `yaml:"sign_servers"`
 
+	QRMaxRefreshes uint `yaml:"qr_max_refreshes"`
+
+	RedactRecalls bool `yaml:"redact_recalls"`
+
+	LeaveGroups bool `yaml:"leave_groups"`
+
+	ForwardDepth uint `yaml:"forward_depth"`
+
+	FaceImageURL string `yaml:"face_image_url"`
+
+	MixedMessages string `yaml:"mixed_messages"`
+
+	Location struct {
+		URL      string `yaml:"url"`
+		TileURL  string `yaml:"tile_url"`
+		TileZoom int    `yaml:"tile_zoom"`
+	} `yaml:"location"`
+
+	Announcements struct {
+		PollInterval uint `yaml:"poll_interval"`
+		Pin          bool `yaml:"pin"`
+	} `yaml:"announcements"`
+
+	StartupSync struct {
+		Enabled        bool     `yaml:"enabled"`
+		Friends        bool     `yaml:"friends"`
+		Limit          int      `yaml:"limit"`
+		SortByActivity bool     `yaml:"sort_by_activity"`
+		IncludeGroups  []uint32 `yaml:"include_groups"`
+		ExcludeGroups  []uint32 `yaml:"exclude_groups"`
+	} `yaml:"startup_sync"`
+
+	Backfill struct {
+		PrivateDepth uint `yaml:"private_depth"`
+		GroupDepth   uint `yaml:"group_depth"`
+	} `yaml:"backfill"`
+
 	Reconnect struct {
 		Delay    uint `yaml:"delay"`
 		MaxTimes uint `yaml:"max_times"`
@@ -44,6 +81,25 @@ func (c *Config) PostProcess() error {
 }
 
 func upgradeConfig(helper up.Helper) {
+	helper.Copy(up.Int, "qr_max_refreshes")
+	helper.Copy(up.Bool, "redact_recalls")
+	helper.Copy(up.Bool, "leave_groups")
+	helper.Copy(up.Int, "forward_depth")
+	helper.Copy(up.Str, "face_image_url")
+	helper.Copy(up.Str, "mixed_messages")
+	helper.Copy(up.Str, "location", "url")
+	helper.Copy(up.Str, "location", "tile_url")
+	helper.Copy(up.Int, "location", "tile_zoom")
+	helper.Copy(up.Int, "announcements", "poll_interval")
+	helper.Copy(up.Bool, "announcements", "pin")
+	helper.Copy(up.Bool, "startup_sync", "enabled")
+	helper.Copy(up.Bool, "startup_sync", "friends")
+	helper.Copy(up.Int, "startup_sync", "limit")
+	helper.Copy(up.Bool, "startup_sync", "sort_by_activity")
+	helper.Copy(up.List, "startup_sync", "include_groups")
+	helper.Copy(up.List, "startup_sync", "exclude_groups")
+	helper.Copy(up.Int, "backfill", "private_depth")
+	helper.Copy(up.Int, "backfill", "group_depth")
 }
 
 func (qc *QQConnector) GetConfig() (example string, data any, upgrader up.Upgrader) {
@@ -3,6 +3,7 @@ package connector
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/duo/matrix-qq/pkg/msgconv"
@@ -23,11 +24,23 @@ type QQConnector struct {
 	Bridge  *bridgev2.Bridge
 	Config  Config
 	MsgConv *msgconv.MessageConverter
+
+	// Recently received group pokes, shared by all logins, see pokeTime
+	recentPokes     map[string]*recentPoke
+	recentPokesLock sync.Mutex
 }
 
 func (qc *QQConnector) Init(bridge *bridgev2.Bridge) {
 	qc.Bridge = bridge
 	qc.MsgConv = msgconv.NewMessageConverter(bridge)
+	qc.MsgConv.ForwardDepth = int(qc.Config.ForwardDepth)
+	qc.MsgConv.FaceImageURL = qc.Config.FaceImageURL
+	qc.MsgConv.MixedMessages = qc.Config.MixedMessages
+	qc.MsgConv.LocationURL = qc.Config.Location.URL
+	qc.MsgConv.MapTileURL = qc.Config.Location.TileURL
+	qc.MsgConv.MapZoom = qc.Config.Location.TileZoom
+
+	qc.registerCommands()
 }
 
 func (qc *QQConnector) Start(ctx context.Context) error {
@@ -57,6 +70,7 @@ func (qc *QQConnector) LoadUserLogin(ctx context.Context, login *bridgev2.UserLo
 		Main:        qc,
 		UserLogin:   login,
 		resyncQueue: make(map[string]resyncQueueItem),
+		muteTimers:  make(map[string]*time.Timer),
 	}
 	login.Client = q
 
@@ -14,7 +14,9 @@ func (qc *QQConnector) GetDBMetaTypes() database.MetaTypes {
 		Ghost: func() any {
 			return &qqid.GhostMetadata{}
 		},
-		Message:  nil,
+		Message: func() any {
+			return &qqid.MessageMetadata{}
+		},
 		Reaction: nil,
 		UserLogin: func() any {
 			return &qqid.UserLoginMetadata{}
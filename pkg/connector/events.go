@@ -3,6 +3,7 @@ package connector
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/duo/matrix-qq/pkg/qqid"
@@ -16,6 +17,9 @@ type QQMessageEvent struct {
 	Message    *qqid.Message
 	qc         *QQClient
 	postHandle func()
+
+	// Set when the group card of the sender changed since it was last seen
+	cardChanged bool
 }
 
 var (
@@ -23,7 +27,6 @@ var (
 	_ bridgev2.RemoteChatResyncWithInfo       = (*QQMessageEvent)(nil)
 	_ bridgev2.RemoteMessage                  = (*QQMessageEvent)(nil)
 	_ bridgev2.RemoteEventWithTimestamp       = (*QQMessageEvent)(nil)
-	_ bridgev2.RemoteMessageRemove            = (*QQMessageEvent)(nil)
 	_ bridgev2.RemotePostHandler              = (*QQMessageEvent)(nil)
 )
 
@@ -55,18 +58,9 @@ func (evt *QQMessageEvent) GetTimestamp() time.Time {
 }
 
 func (evt *QQMessageEvent) GetType() bridgev2.RemoteEventType {
-	/*
-		if evt.Message.Type == qqid.MsgRevoke {
-			return bridgev2.RemoteEventMessageRemove
-		}
-	*/
 	return bridgev2.RemoteEventMessage
 }
 
-func (evt *QQMessageEvent) GetTargetMessage() networkid.MessageID {
-	return qqid.MakeMessageID(evt.Message.ChatID, evt.Message.ID)
-}
-
 func (evt *QQMessageEvent) GetChatInfo(ctx context.Context, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
 	switch evt.Message.ChatType {
 	case qqid.ChatPrivate:
@@ -87,6 +81,18 @@ func (evt *QQMessageEvent) PostHandle(ctx context.Context, portal *bridgev2.Port
 	if ph := evt.postHandle; ph != nil {
 		evt.postHandle = nil
 		ph()
+	} else if evt.cardChanged && portal.MXID != "" {
+		evt.cardChanged = false
+		groupUin, _ := strconv.ParseUint(evt.Message.ChatID, 10, 32)
+		senderUin, _ := strconv.ParseUint(evt.Message.SenderID, 10, 32)
+		// Refreshing the member takes a round trip, which shouldn't hold up the following events
+		go func(ctx context.Context) {
+			if err := evt.qc.Client.RefreshGroupMemberCache(uint32(groupUin), uint32(senderUin)); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to refresh group member")
+				return
+			}
+			evt.qc.updateSingleMemberDisplayname(ctx, portal, uint32(groupUin), uint32(senderUin))
+		}(context.WithoutCancel(ctx))
 	}
 }
 
@@ -95,3 +101,37 @@ func (evt *QQMessageEvent) ConvertMessage(ctx context.Context, portal *bridgev2.
 
 	return evt.qc.Main.MsgConv.ToMatrix(ctx, evt.qc.Client, portal, intent, evt.Message), nil
 }
+
+type QQRecallEvent struct {
+	Message *qqid.Message
+	qc      *QQClient
+}
+
+var (
+	_ bridgev2.RemoteMessageRemove      = (*QQRecallEvent)(nil)
+	_ bridgev2.RemoteEventWithTimestamp = (*QQRecallEvent)(nil)
+)
+
+func (evt *QQRecallEvent) AddLogContext(c zerolog.Context) zerolog.Context {
+	return c.Str("target_message_id", evt.Message.ID).Str("sender_id", evt.Message.SenderID)
+}
+
+func (evt *QQRecallEvent) GetPortalKey() networkid.PortalKey {
+	return evt.qc.makePortalKey(evt.Message.ChatType, evt.Message.ChatID)
+}
+
+func (evt *QQRecallEvent) GetSender() bridgev2.EventSender {
+	return evt.qc.makeEventSender(evt.Message.SenderID)
+}
+
+func (evt *QQRecallEvent) GetTimestamp() time.Time {
+	return time.UnixMilli(evt.Message.Timestamp)
+}
+
+func (evt *QQRecallEvent) GetType() bridgev2.RemoteEventType {
+	return bridgev2.RemoteEventMessageRemove
+}
+
+func (evt *QQRecallEvent) GetTargetMessage() networkid.MessageID {
+	return qqid.MakeMessageID(evt.Message.ChatID, evt.Message.ID)
+}
@@ -0,0 +1,83 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+)
+
+// QQForwardEvent bridges a merged forward sent with the forward command back to the target room.
+// The notice is mapped to the sent forward, so redacting it recalls the forward.
+type QQForwardEvent struct {
+	Message *database.Message
+	Source  *bridgev2.Portal
+	Target  *bridgev2.Portal
+	Count   int
+	qc      *QQClient
+}
+
+var (
+	_ bridgev2.RemoteMessage            = (*QQForwardEvent)(nil)
+	_ bridgev2.RemoteEventWithTimestamp = (*QQForwardEvent)(nil)
+)
+
+func (evt *QQForwardEvent) AddLogContext(c zerolog.Context) zerolog.Context {
+	return c.Str("message_id", string(evt.Message.ID)).Stringer("source_room_id", evt.Source.MXID)
+}
+
+func (evt *QQForwardEvent) GetPortalKey() networkid.PortalKey {
+	return evt.Target.PortalKey
+}
+
+func (evt *QQForwardEvent) GetSender() bridgev2.EventSender {
+	return evt.qc.selfEventSender()
+}
+
+func (evt *QQForwardEvent) GetID() networkid.MessageID {
+	return evt.Message.ID
+}
+
+func (evt *QQForwardEvent) GetTimestamp() time.Time {
+	return evt.Message.Timestamp
+}
+
+func (evt *QQForwardEvent) GetType() bridgev2.RemoteEventType {
+	return bridgev2.RemoteEventMessage
+}
+
+func (evt *QQForwardEvent) ConvertMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI) (*bridgev2.ConvertedMessage, error) {
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgNotice,
+		Body:     fmt.Sprintf("Forwarded %d messages", evt.Count),
+		Format:   event.FormatHTML,
+		Mentions: &event.Mentions{},
+	}
+	content.FormattedBody = html.EscapeString(content.Body)
+
+	if evt.Source.PortalKey != evt.Target.PortalKey {
+		name := evt.Source.Name
+		if name == "" {
+			name = evt.Source.MXID.String()
+		}
+		content.Body += " from " + name
+		content.FormattedBody += fmt.Sprintf(
+			` from <a href="%s">%s</a>`,
+			evt.Source.MXID.URI().MatrixToURL(), html.EscapeString(name),
+		)
+	}
+
+	return &bridgev2.ConvertedMessage{
+		Parts: []*bridgev2.ConvertedMessagePart{{
+			Type:       event.EventMessage,
+			Content:    content,
+			DBMetadata: evt.Message.Metadata,
+		}},
+	}, nil
+}
@@ -1,18 +1,44 @@
 package connector
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
 
+	"github.com/duo/matrix-qq/pkg/msgconv"
 	"github.com/duo/matrix-qq/pkg/qqid"
 
+	"github.com/LagrangeDev/LagrangeGo/client/entity"
 	"github.com/LagrangeDev/LagrangeGo/message"
+	"github.com/rs/zerolog"
 	"maunium.net/go/mautrix/bridgev2"
 	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/bridgev2/matrix"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
+var (
+	ErrRecallTooOld      error = bridgev2.WrapErrorInStatus(errors.New("the message is too old to be recalled")).WithErrorReason(event.MessageStatusUnsupported).WithIsCertain(true).WithErrorAsMessage().WithSendNotice(true)
+	ErrRecallOthersInDM  error = bridgev2.WrapErrorInStatus(errors.New("can't recall messages sent by the other user")).WithErrorReason(event.MessageStatusUnsupported).WithIsCertain(true).WithErrorAsMessage().WithSendNotice(true)
+	ErrRecallUnsupported error = bridgev2.WrapErrorInStatus(errors.New("recalling messages is not supported in this chat")).WithErrorReason(event.MessageStatusUnsupported).WithIsCertain(true).WithErrorAsMessage().WithSendNotice(true)
+
+	ErrReactionUnsupported error = bridgev2.WrapErrorInStatus(errors.New("reactions are only supported in group chats")).WithErrorReason(event.MessageStatusUnsupported).WithIsCertain(true).WithErrorAsMessage().WithSendNotice(false)
+
+	ErrNotGroupAdmin     error = bridgev2.WrapErrorInStatus(errors.New("you are not an admin of this group")).WithErrorReason(event.MessageStatusNoPermission).WithIsCertain(true).WithErrorAsMessage().WithSendNotice(true)
+	ErrKickAdmin         error = bridgev2.WrapErrorInStatus(errors.New("only the group owner can remove admins")).WithErrorReason(event.MessageStatusNoPermission).WithIsCertain(true).WithErrorAsMessage().WithSendNotice(true)
+	ErrInviteUnsupported error = bridgev2.WrapErrorInStatus(errors.New("inviting users to QQ groups is not supported")).WithErrorReason(event.MessageStatusUnsupported).WithIsCertain(true).WithErrorAsMessage().WithSendNotice(true)
+	ErrGroupOnly         error = bridgev2.WrapErrorInStatus(errors.New("room metadata can only be changed in group chats")).WithErrorReason(event.MessageStatusUnsupported).WithIsCertain(true).WithErrorAsMessage().WithSendNotice(true)
+)
+
+// QQ doesn't allow muting members for longer than 30 days
+const maxMuteDuration = 30 * 24 * time.Hour
+
 func (qc *QQClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.MatrixMessage) (*bridgev2.MatrixMessageResponse, error) {
 	if !qc.IsLoggedIn() {
 		return nil, bridgev2.ErrNotLoggedIn
@@ -39,9 +65,21 @@ func (qc *QQClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.Matri
 		}
 	}
 
-	target, _ := strconv.ParseUint(string(msg.Portal.ID), 10, 32)
+	resp, err := qc.sendElements(msg.Portal, elements, msg.Content.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.DB.Metadata.(*qqid.MessageMetadata).MediaType = qqid.MediaMsgType(msg.Event.Type, msg.Content)
 
-	meta := msg.Portal.Metadata.(*qqid.PortalMetadata)
+	return resp, nil
+}
+
+// sendElements sends already converted elements to the chat of the portal.
+// text is kept in the message metadata as the plain text copy.
+func (qc *QQClient) sendElements(portal *bridgev2.Portal, elements []message.IMessageElement, text string) (*bridgev2.MatrixMessageResponse, error) {
+	target, _ := strconv.ParseUint(string(portal.ID), 10, 32)
+
+	meta := portal.Metadata.(*qqid.PortalMetadata)
 	switch meta.ChatType {
 	case qqid.ChatPrivate:
 		if resp, err := qc.Client.SendPrivateMessage(uint32(target), elements); err != nil {
@@ -51,9 +89,14 @@ func (qc *QQClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.Matri
 		} else {
 			return &bridgev2.MatrixMessageResponse{
 				DB: &database.Message{
-					ID:        qqid.MakeMessageID(string(msg.Portal.ID), fmt.Sprint(resp.ID)),
+					ID:        qqid.MakeMessageID(string(portal.ID), fmt.Sprint(resp.ID)),
 					SenderID:  qqid.MakeUserID(fmt.Sprint(resp.Sender.Uin)),
 					Timestamp: time.UnixMilli(int64(resp.Time) * 1000),
+					Metadata: &qqid.MessageMetadata{
+						Random:    resp.InternalID,
+						ClientSeq: resp.ClientSeq,
+						Text:      text,
+					},
 				},
 				StreamOrder: time.UnixMilli(int64(resp.Time) * 1000).Unix(),
 			}, nil
@@ -64,9 +107,12 @@ func (qc *QQClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.Matri
 		} else {
 			return &bridgev2.MatrixMessageResponse{
 				DB: &database.Message{
-					ID:        qqid.MakeMessageID(string(msg.Portal.ID), fmt.Sprint(resp.ID)),
+					ID:        qqid.MakeMessageID(string(portal.ID), fmt.Sprint(resp.ID)),
 					SenderID:  qqid.MakeUserID(fmt.Sprint(resp.Sender.Uin)),
 					Timestamp: time.UnixMilli(int64(resp.Time) * 1000),
+					Metadata: &qqid.MessageMetadata{
+						Text: text,
+					},
 				},
 				StreamOrder: time.UnixMilli(int64(resp.Time) * 1000).Unix(),
 			}, nil
@@ -75,3 +121,535 @@ func (qc *QQClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.Matri
 		return nil, fmt.Errorf("unknown chat type")
 	}
 }
+
+// sendMergedForward sends bridged messages of the source portal to the chat of the target portal as one merged forward.
+// A notice for the forward is then bridged to the target room like any other message.
+func (qc *QQClient) sendMergedForward(ctx context.Context, source, target *bridgev2.Portal, messages []*database.Message) error {
+	sourceMeta := source.Metadata.(*qqid.PortalMetadata)
+
+	var groupUin uint32
+	if target.Metadata.(*qqid.PortalMetadata).ChatType == qqid.ChatGroup {
+		uin, _ := strconv.ParseUint(string(target.ID), 10, 32)
+		groupUin = uint32(uin)
+	}
+
+	forward := &message.ForwardMessage{
+		IsGroup: groupUin != 0,
+		SelfID:  qc.Client.Uin,
+	}
+	var node *message.ForwardNode
+	var nodeID networkid.MessageID
+	for _, msg := range messages {
+		// Skip bridge generated notices
+		if _, err := qqid.ParseMessageID(msg.ID); err != nil {
+			continue
+		}
+
+		// Parts of the same message are merged into one node
+		if node == nil || msg.ID != nodeID {
+			senderID, err := strconv.ParseUint(string(msg.SenderID), 10, 32)
+			if err != nil {
+				continue
+			}
+
+			var fallback string
+			if ghost, err := qc.Main.Bridge.GetGhostByID(ctx, msg.SenderID); err == nil {
+				fallback = ghost.Name
+			}
+
+			node = &message.ForwardNode{
+				GroupID:    groupUin,
+				SenderID:   uint32(senderID),
+				SenderName: qc.getMemberName(sourceMeta.ChatType, string(source.ID), string(msg.SenderID), fallback),
+				Time:       uint32(msg.Timestamp.Unix()),
+			}
+			nodeID = msg.ID
+			forward.Nodes = append(forward.Nodes, node)
+		}
+
+		node.Message = append(node.Message, qc.makeForwardElement(ctx, source, target, groupUin, msg))
+	}
+	if len(forward.Nodes) == 0 {
+		return fmt.Errorf("no bridged messages to forward")
+	}
+
+	forward, err := qc.Client.UploadForwardMsg(forward, groupUin)
+	if err != nil {
+		return fmt.Errorf("failed to upload merged forward: %w", err)
+	}
+
+	resp, err := qc.sendElements(target, []message.IMessageElement{forward}, fmt.Sprintf("[Forward: %d messages]", len(forward.Nodes)))
+	if err != nil {
+		return err
+	}
+
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &QQForwardEvent{
+		Message: resp.DB,
+		Source:  source,
+		Target:  target,
+		Count:   len(forward.Nodes),
+		qc:      qc,
+	})
+	return nil
+}
+
+// makeForwardElement converts one bridged message part to an element of a merged forward.
+// Images and videos are uploaded again, everything else is forwarded as its text.
+func (qc *QQClient) makeForwardElement(ctx context.Context, source, target *bridgev2.Portal, groupUin uint32, msg *database.Message) message.IMessageElement {
+	msgMeta, _ := msg.Metadata.(*qqid.MessageMetadata)
+	if msgMeta == nil {
+		return message.NewText("[Message]")
+	}
+
+	text := msgMeta.Text
+	if msgMeta.PartText != "" {
+		text = msgMeta.PartText
+	}
+	if text == "" {
+		text = "[Message]"
+	}
+
+	mediaType := msgMeta.MediaType
+	// QQ can't show voice messages and files inside merged forwards
+	if mediaType != event.MsgImage && mediaType != event.MsgVideo && mediaType != event.MessageType(event.EventSticker.Type) {
+		return message.NewText(text)
+	}
+
+	log := zerolog.Ctx(ctx).With().Str("message_id", string(msg.ID)).Stringer("event_id", msg.MXID).Logger()
+	content, err := qc.getMessageContent(ctx, source.MXID, msg.MXID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get media event for merged forward")
+		return message.NewText(text)
+	}
+	mediaURL := content.URL
+	if content.File != nil {
+		mediaURL = content.File.URL
+	}
+	data, err := qc.Main.Bridge.Bot.DownloadMedia(ctx, mediaURL, content.File)
+	if err != nil {
+		log.Err(err).Msg("Failed to download media for merged forward")
+		return message.NewText(text)
+	}
+
+	var elem message.IMessageElement
+	if mediaType == event.MsgVideo {
+		video := message.NewVideo(data, qqid.SmallestImg)
+		if groupUin != 0 {
+			_, err = qc.Client.UploadGroupVideo(groupUin, video)
+		} else {
+			_, err = qc.Client.UploadPrivateVideo(qc.Client.GetUID(qc.getPrivateUin(target)), video)
+		}
+		elem = video
+	} else {
+		image := message.NewImage(data)
+		if groupUin != 0 {
+			_, err = qc.Client.UploadGroupImage(groupUin, image)
+		} else {
+			_, err = qc.Client.UploadPrivateImage(qc.Client.GetUID(qc.getPrivateUin(target)), image)
+		}
+		elem = image
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to upload media for merged forward")
+		return message.NewText(text)
+	}
+
+	return elem
+}
+
+// getMessageContent fetches a bridged Matrix message again.
+// File keys of encrypted media aren't stored, so media can only be downloaded again from the original event.
+func (qc *QQClient) getMessageContent(ctx context.Context, roomID id.RoomID, eventID id.EventID) (*event.MessageEventContent, error) {
+	mx, ok := qc.Main.Bridge.Matrix.(*matrix.Connector)
+	if !ok {
+		return nil, fmt.Errorf("matrix connector can't fetch events")
+	}
+
+	evt, err := mx.Bot.GetEvent(ctx, roomID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	evt.Type.Class = event.MessageEventType
+	if err := evt.Content.ParseRaw(evt.Type); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	if evt.Type == event.EventEncrypted {
+		if mx.Crypto == nil {
+			return nil, fmt.Errorf("event is encrypted, but encryption is disabled")
+		}
+		if evt, err = mx.Crypto.Decrypt(ctx, evt); err != nil {
+			return nil, fmt.Errorf("failed to decrypt event: %w", err)
+		}
+	}
+
+	content, ok := evt.Content.Parsed.(*event.MessageEventContent)
+	if !ok {
+		return nil, fmt.Errorf("unexpected event type %s", evt.Type.Type)
+	}
+	return content, nil
+}
+
+// getPrivateUin returns the UIN of the other user of a private chat.
+func (qc *QQClient) getPrivateUin(portal *bridgev2.Portal) uint32 {
+	uin, _ := strconv.ParseUint(string(portal.ID), 10, 32)
+	return uint32(uin)
+}
+
+func (qc *QQClient) HandleMatrixMessageRemove(ctx context.Context, msg *bridgev2.MatrixMessageRemove) error {
+	if !qc.IsLoggedIn() {
+		return bridgev2.ErrNotLoggedIn
+	}
+
+	msgID, err := qqid.ParseMessageID(msg.TargetMessage.ID)
+	if err != nil {
+		return err
+	}
+	seq, err := strconv.ParseUint(msgID.ID, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid message sequence: %w", err)
+	}
+
+	// QQ only limits the recall window for own messages, group admins can recall others at any time
+	isFromMe := networkid.UserLoginID(msg.TargetMessage.SenderID) == qc.UserLogin.ID
+	if isFromMe && time.Since(msg.TargetMessage.Timestamp) > MaxRecallAge {
+		return ErrRecallTooOld
+	}
+
+	target, _ := strconv.ParseUint(string(msg.Portal.ID), 10, 32)
+
+	meta := msg.Portal.Metadata.(*qqid.PortalMetadata)
+	switch meta.ChatType {
+	case qqid.ChatPrivate:
+		if !isFromMe {
+			return ErrRecallOthersInDM
+		}
+		msgMeta, _ := msg.TargetMessage.Metadata.(*qqid.MessageMetadata)
+		if msgMeta == nil || msgMeta.Random == 0 {
+			return ErrRecallUnsupported
+		}
+		err = qc.Client.RecallFriendMessage(
+			uint32(target),
+			uint32(seq),
+			msgMeta.Random,
+			msgMeta.ClientSeq,
+			uint32(msg.TargetMessage.Timestamp.Unix()),
+		)
+	case qqid.ChatGroup:
+		err = qc.Client.RecallGroupMessage(uint32(target), uint32(seq))
+	default:
+		return ErrRecallUnsupported
+	}
+
+	if err != nil {
+		return bridgev2.WrapErrorInStatus(fmt.Errorf("failed to recall message: %w", err)).WithErrorAsMessage().WithSendNotice(true)
+	}
+
+	qc.redactOtherParts(ctx, msg.Portal, msg.TargetMessage)
+
+	return nil
+}
+
+// redactOtherParts redacts the remaining Matrix events of a recalled message,
+// as a recall removes every part of a QQ message bridged as multiple events.
+func (qc *QQClient) redactOtherParts(ctx context.Context, portal *bridgev2.Portal, target *database.Message) {
+	log := zerolog.Ctx(ctx)
+
+	parts, err := qc.Main.Bridge.DB.Message.GetAllPartsByID(ctx, portal.Receiver, target.ID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get other parts of recalled message")
+		return
+	}
+
+	intent := portal.GetIntentFor(ctx, qc.makeEventSender(string(target.SenderID)), qc.UserLogin, bridgev2.RemoteEventMessageRemove)
+	for _, part := range parts {
+		if part.MXID == target.MXID || part.HasFakeMXID() {
+			continue
+		}
+		_, err := intent.SendMessage(ctx, portal.MXID, event.EventRedaction, &event.Content{
+			Parsed: &event.RedactionEventContent{Redacts: part.MXID},
+		}, nil)
+		if err != nil {
+			log.Err(err).Stringer("part_mxid", part.MXID).Msg("Failed to redact other part of recalled message")
+		}
+	}
+
+	// Nothing is left to address once the message is recalled
+	if err := qc.Main.Bridge.DB.Message.DeleteAllParts(ctx, portal.Receiver, target.ID); err != nil {
+		log.Err(err).Msg("Failed to delete recalled message from database")
+	}
+}
+
+func (qc *QQClient) PreHandleMatrixReaction(ctx context.Context, msg *bridgev2.MatrixReaction) (bridgev2.MatrixReactionPreResponse, error) {
+	if msg.Portal.Metadata.(*qqid.PortalMetadata).ChatType != qqid.ChatGroup {
+		return bridgev2.MatrixReactionPreResponse{}, ErrReactionUnsupported
+	}
+
+	code, err := msgconv.ReactionCodeFromEmoji(msg.Content.RelatesTo.Key)
+	if err != nil {
+		return bridgev2.MatrixReactionPreResponse{}, bridgev2.WrapErrorInStatus(err).WithErrorReason(event.MessageStatusUnsupported).WithIsCertain(true).WithErrorAsMessage()
+	}
+
+	return bridgev2.MatrixReactionPreResponse{
+		SenderID: networkid.UserID(qc.UserLogin.ID),
+		EmojiID:  networkid.EmojiID(code),
+		Emoji:    msg.Content.RelatesTo.Key,
+	}, nil
+}
+
+func (qc *QQClient) HandleMatrixReaction(ctx context.Context, msg *bridgev2.MatrixReaction) (*database.Reaction, error) {
+	if !qc.IsLoggedIn() {
+		return nil, bridgev2.ErrNotLoggedIn
+	}
+
+	if err := qc.setGroupReaction(msg.Portal, msg.TargetMessage.ID, msg.PreHandleResp.EmojiID, true); err != nil {
+		return nil, bridgev2.WrapErrorInStatus(err).WithSendNotice(true)
+	}
+
+	return &database.Reaction{}, nil
+}
+
+func (qc *QQClient) HandleMatrixReactionRemove(ctx context.Context, msg *bridgev2.MatrixReactionRemove) error {
+	if !qc.IsLoggedIn() {
+		return bridgev2.ErrNotLoggedIn
+	}
+
+	if err := qc.setGroupReaction(msg.Portal, msg.TargetReaction.MessageID, msg.TargetReaction.EmojiID, false); err != nil {
+		return bridgev2.WrapErrorInStatus(err).WithSendNotice(true)
+	}
+
+	return nil
+}
+
+func (qc *QQClient) setGroupReaction(portal *bridgev2.Portal, messageID networkid.MessageID, emojiID networkid.EmojiID, isAdd bool) error {
+	if portal.Metadata.(*qqid.PortalMetadata).ChatType != qqid.ChatGroup {
+		return ErrReactionUnsupported
+	}
+
+	msgID, err := qqid.ParseMessageID(messageID)
+	if err != nil {
+		return err
+	}
+	seq, err := strconv.ParseUint(msgID.ID, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid message sequence: %w", err)
+	}
+	target, _ := strconv.ParseUint(string(portal.ID), 10, 32)
+
+	if err := qc.Client.SetGroupReaction(uint32(target), uint32(seq), string(emojiID), isAdd); err != nil {
+		return fmt.Errorf("failed to set group reaction: %w", err)
+	}
+
+	return nil
+}
+
+func (qc *QQClient) HandleMatrixPowerLevels(ctx context.Context, msg *bridgev2.MatrixPowerLevelChange) (bool, error) {
+	if !qc.IsLoggedIn() {
+		return false, bridgev2.ErrNotLoggedIn
+	}
+
+	meta := msg.Portal.Metadata.(*qqid.PortalMetadata)
+	if meta.ChatType != qqid.ChatGroup {
+		return false, nil
+	}
+
+	groupUin, _ := strconv.ParseUint(string(msg.Portal.ID), 10, 32)
+
+	// Collect the mute changes first, other power level changes don't need admin rights
+	var muteAll *bool
+	if change := msg.EventsDefault; change != nil {
+		if isMuted := change.NewLevel > powerDefault; isMuted != (change.OrigLevel > powerDefault) {
+			muteAll = &isMuted
+		}
+	}
+
+	memberMutes := make(map[uint32]bool)
+	for _, change := range msg.Users {
+		ghost, ok := change.Target.(*bridgev2.Ghost)
+		if !ok {
+			continue
+		}
+		uin, err := strconv.ParseUint(string(ghost.ID), 10, 32)
+		if err != nil {
+			continue
+		}
+
+		// Individual mutes are independent of whole group mutes
+		wasMuted := change.OrigLevel < powerDefault
+		isMuted := change.NewLevel < powerDefault
+		if wasMuted != isMuted {
+			memberMutes[uint32(uin)] = isMuted
+		}
+	}
+
+	if muteAll == nil && len(memberMutes) == 0 {
+		return false, nil
+	}
+	if !qc.isGroupAdmin(uint32(groupUin)) {
+		return false, ErrNotGroupAdmin
+	}
+
+	handled := false
+
+	if muteAll != nil {
+		if err := qc.Client.SetGroupGlobalMute(uint32(groupUin), *muteAll); err != nil {
+			return false, bridgev2.WrapErrorInStatus(fmt.Errorf("failed to mute group: %w", err)).WithErrorAsMessage().WithSendNotice(true)
+		}
+		handled = true
+	}
+
+	for uin, isMuted := range memberMutes {
+		var duration time.Duration
+		if isMuted {
+			duration = maxMuteDuration
+		}
+		if err := qc.Client.SetGroupMemberMute(uint32(groupUin), uin, uint32(duration.Seconds())); err != nil {
+			return handled, bridgev2.WrapErrorInStatus(fmt.Errorf("failed to mute member %d: %w", uin, err)).WithErrorAsMessage().WithSendNotice(true)
+		}
+		handled = true
+	}
+
+	return handled, nil
+}
+
+func (qc *QQClient) isGroupAdmin(groupUin uint32) bool {
+	self := qc.Client.GetCachedMemberInfo(qc.Client.Uin, groupUin)
+	return self != nil && (self.Permission == entity.Owner || self.Permission == entity.Admin)
+}
+
+func (qc *QQClient) HandleMatrixMembership(ctx context.Context, msg *bridgev2.MatrixMembershipChange) (bool, error) {
+	if !qc.IsLoggedIn() {
+		return false, bridgev2.ErrNotLoggedIn
+	}
+
+	meta := msg.Portal.Metadata.(*qqid.PortalMetadata)
+	if meta.ChatType != qqid.ChatGroup {
+		return false, nil
+	}
+
+	groupUin, _ := strconv.ParseUint(string(msg.Portal.ID), 10, 32)
+
+	switch msg.Type {
+	case bridgev2.Leave:
+		if !qc.Main.Config.LeaveGroups {
+			return false, nil
+		}
+		if err := qc.Client.SetGroupLeave(uint32(groupUin)); err != nil {
+			return false, bridgev2.WrapErrorInStatus(fmt.Errorf("failed to leave group: %w", err)).WithErrorAsMessage().WithSendNotice(true)
+		}
+		return true, nil
+	case bridgev2.Kick, bridgev2.BanJoined:
+		ghost, ok := msg.Target.(*bridgev2.Ghost)
+		if !ok {
+			return false, nil
+		}
+		uin, err := strconv.ParseUint(string(ghost.ID), 10, 32)
+		if err != nil {
+			return false, fmt.Errorf("invalid user ID %s: %w", ghost.ID, err)
+		}
+
+		if !qc.isGroupAdmin(uint32(groupUin)) {
+			return false, ErrNotGroupAdmin
+		}
+		if target := qc.Client.GetCachedMemberInfo(uint32(uin), uint32(groupUin)); target != nil && target.Permission != entity.Member {
+			if self := qc.Client.GetCachedMemberInfo(qc.Client.Uin, uint32(groupUin)); self == nil || self.Permission != entity.Owner {
+				return false, ErrKickAdmin
+			}
+		}
+
+		// Bans also reject future join requests from the user
+		rejectAddRequest := msg.Type == bridgev2.BanJoined
+		if err := qc.Client.KickGroupMember(uint32(groupUin), uint32(uin), rejectAddRequest); err != nil {
+			return false, bridgev2.WrapErrorInStatus(fmt.Errorf("failed to kick member %d: %w", uin, err)).WithErrorAsMessage().WithSendNotice(true)
+		}
+		return true, nil
+	case bridgev2.Invite:
+		if !qc.isGroupAdmin(uint32(groupUin)) {
+			return false, ErrNotGroupAdmin
+		}
+		// LagrangeGo has no API for inviting users to groups yet
+		return false, ErrInviteUnsupported
+	default:
+		return false, nil
+	}
+}
+
+func (qc *QQClient) HandleMatrixRoomName(ctx context.Context, msg *bridgev2.MatrixRoomName) (bool, error) {
+	groupUin, err := qc.checkGroupMetaChange(msg.Portal)
+	if err != nil {
+		return false, err
+	}
+
+	if err := qc.Client.SetGroupName(groupUin, msg.Content.Name); err != nil {
+		return false, bridgev2.WrapErrorInStatus(fmt.Errorf("failed to rename group: %w", err)).WithErrorAsMessage().WithSendNotice(true)
+	}
+
+	msg.Portal.Name = msg.Content.Name
+	msg.Portal.NameSet = true
+
+	return true, nil
+}
+
+func (qc *QQClient) HandleMatrixRoomAvatar(ctx context.Context, msg *bridgev2.MatrixRoomAvatar) (bool, error) {
+	groupUin, err := qc.checkGroupMetaChange(msg.Portal)
+	if err != nil {
+		return false, err
+	}
+
+	// QQ groups always have an avatar
+	if msg.Content.URL == "" {
+		return false, nil
+	}
+
+	data, err := qc.Main.Bridge.Bot.DownloadMedia(ctx, msg.Content.URL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to download avatar: %w", err)
+	}
+	if err := qc.Client.SetGroupAvatar(groupUin, bytes.NewReader(data)); err != nil {
+		return false, bridgev2.WrapErrorInStatus(fmt.Errorf("failed to set group avatar: %w", err)).WithErrorAsMessage().WithSendNotice(true)
+	}
+
+	msg.Portal.AvatarMXC = msg.Content.URL
+	msg.Portal.AvatarHash = sha256.Sum256(data)
+	msg.Portal.AvatarSet = true
+
+	return true, nil
+}
+
+func (qc *QQClient) HandleMatrixRoomTopic(ctx context.Context, msg *bridgev2.MatrixRoomTopic) (bool, error) {
+	groupUin, err := qc.checkGroupMetaChange(msg.Portal)
+	if err != nil {
+		return false, err
+	}
+
+	// The closest thing to a topic is the group announcement
+	if msg.Content.Topic != "" {
+		noticeID, err := qc.Client.AddGroupNoticeSimple(groupUin, msg.Content.Topic)
+		if err != nil {
+			return false, bridgev2.WrapErrorInStatus(fmt.Errorf("failed to publish group announcement: %w", err)).WithErrorAsMessage().WithSendNotice(true)
+		}
+		// Don't bridge our own announcement back as a notice
+		msg.Portal.Metadata.(*qqid.PortalMetadata).LastNoticeID = noticeID
+	}
+
+	msg.Portal.Topic = msg.Content.Topic
+	msg.Portal.TopicSet = true
+
+	return true, nil
+}
+
+func (qc *QQClient) checkGroupMetaChange(portal *bridgev2.Portal) (uint32, error) {
+	if !qc.IsLoggedIn() {
+		return 0, bridgev2.ErrNotLoggedIn
+	}
+
+	if portal.Metadata.(*qqid.PortalMetadata).ChatType != qqid.ChatGroup {
+		return 0, ErrGroupOnly
+	}
+
+	groupUin, _ := strconv.ParseUint(string(portal.ID), 10, 32)
+	if !qc.isGroupAdmin(uint32(groupUin)) {
+		return 0, ErrNotGroupAdmin
+	}
+
+	return uint32(groupUin), nil
+}
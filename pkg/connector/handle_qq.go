@@ -1,13 +1,26 @@
 package connector
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"html"
+	"strings"
+	"time"
 
+	"github.com/duo/matrix-qq/pkg/msgconv"
 	"github.com/duo/matrix-qq/pkg/qqid"
 
 	"github.com/LagrangeDev/LagrangeGo/client"
 	"github.com/LagrangeDev/LagrangeGo/client/event"
 	"github.com/LagrangeDev/LagrangeGo/message"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ptr"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/bridgev2/simplevent"
+	mxevent "maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
 )
 
 func (qc *QQClient) handlePrivateMessage(_ *client.QQClient, msg *message.PrivateMessage) {
@@ -50,40 +63,641 @@ func (qc *QQClient) handleGroupMessage(_ *client.QQClient, msg *message.GroupMes
 			SenderID:  fmt.Sprint(msg.Sender.Uin),
 			Elements:  msg.Elements,
 		},
-		qc: qc,
+		qc:          qc,
+		cardChanged: qc.checkMemberCard(msg.GroupUin, msg.Sender),
 	})
 }
 
+// checkMemberCard detects group card changes from the sender info of group messages,
+// as QQ doesn't push an event for them. A cleared card falls back to the nickname.
+// The member cache is refreshed after the message is handled, see QQMessageEvent.PostHandle.
+func (qc *QQClient) checkMemberCard(groupUin uint32, sender *message.Sender) bool {
+	name := cmp.Or(sender.CardName, sender.Nickname)
+	if name == "" {
+		return false
+	}
+
+	key := fmt.Sprintf("%d:%d", groupUin, sender.Uin)
+	if prev, loaded := qc.seenCards.Swap(key, name); loaded && prev == name {
+		return false
+	}
+
+	member := qc.Client.GetCachedMemberInfo(sender.Uin, groupUin)
+	return member != nil && cmp.Or(member.MemberCard, member.Nickname) != name
+}
+
 func (qc *QQClient) handleFriendRecall(_ *client.QQClient, evt *event.FriendRecall) {
 	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ friend recall event")
 
-	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &QQMessageEvent{
-		Message: &qqid.Message{
-			ID:        fmt.Sprint(evt.Sequence),
-			Timestamp: int64(evt.Time) * 1000,
-			Type:      qqid.MsgRevoke,
-			ChatID:    fmt.Sprint(evt.FromUin),
-			ChatType:  qqid.ChatPrivate,
-			SenderID:  fmt.Sprint(evt.FromUin),
-			Elements:  make([]message.IMessageElement, 0),
-		},
-		qc: qc,
+	qc.queueRecall(&qqid.Message{
+		ID:        fmt.Sprint(evt.Sequence),
+		Timestamp: int64(evt.Time) * 1000,
+		Type:      qqid.MsgRevoke,
+		ChatID:    fmt.Sprint(evt.FromUin),
+		ChatType:  qqid.ChatPrivate,
+		SenderID:  fmt.Sprint(evt.FromUin),
+		Elements:  make([]message.IMessageElement, 0),
 	})
 }
 
 func (qc *QQClient) handleGroupRecall(_ *client.QQClient, evt *event.GroupRecall) {
 	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group recall event")
 
-	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &QQMessageEvent{
-		Message: &qqid.Message{
-			ID:        fmt.Sprint(evt.Sequence),
-			Timestamp: int64(evt.Time) * 1000,
-			Type:      qqid.MsgRevoke,
-			ChatID:    fmt.Sprint(evt.GroupUin),
-			ChatType:  qqid.ChatGroup,
-			SenderID:  fmt.Sprint(evt.OperatorUin),
-			Elements:  make([]message.IMessageElement, 0),
+	qc.queueRecall(&qqid.Message{
+		ID:        fmt.Sprint(evt.Sequence),
+		Timestamp: int64(evt.Time) * 1000,
+		Type:      qqid.MsgRevoke,
+		ChatID:    fmt.Sprint(evt.GroupUin),
+		ChatType:  qqid.ChatGroup,
+		SenderID:  fmt.Sprint(evt.OperatorUin),
+		Elements:  make([]message.IMessageElement, 0),
+	})
+}
+
+func (qc *QQClient) queueRecall(msg *qqid.Message) {
+	if qc.Main.Config.RedactRecalls {
+		qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &QQRecallEvent{
+			Message: msg,
+			qc:      qc,
+		})
+	} else {
+		qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &QQMessageEvent{
+			Message: msg,
+			qc:      qc,
+		})
+	}
+}
+
+func (qc *QQClient) handleGroupReaction(_ *client.QQClient, evt *event.GroupReactionEvent) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group reaction event")
+
+	evtType := bridgev2.RemoteEventReaction
+	if !evt.IsAdd {
+		evtType = bridgev2.RemoteEventReactionRemove
+	}
+
+	chatID := fmt.Sprint(evt.GroupUin)
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.Reaction{
+		EventMeta: simplevent.EventMeta{
+			Type: evtType,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.Uint32("target_seq", evt.TargetSeq).Str("code", evt.Code)
+			},
+			PortalKey: qc.makePortalKey(qqid.ChatGroup, chatID),
+			Sender:    qc.makeEventSender(fmt.Sprint(evt.UserUin)),
+			Timestamp: time.Now(),
 		},
-		qc: qc,
+		TargetMessage: qqid.MakeMessageID(chatID, fmt.Sprint(evt.TargetSeq)),
+		EmojiID:       networkid.EmojiID(evt.Code),
+		Emoji:         msgconv.EmojiFromReactionCode(evt.Code, evt.IsEmoji),
+	})
+}
+
+func (qc *QQClient) handleGroupJoin(_ *client.QQClient, evt *event.GroupMemberIncrease) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group join event")
+
+	if err := qc.Client.RefreshGroupMembersCache(evt.GroupUin); err != nil {
+		qc.UserLogin.Log.Warn().Err(err).Uint32("group_uin", evt.GroupUin).Msg("Failed to refresh group members")
+	}
+
+	qc.queueGroupResync(evt.GroupUin, "join")
+}
+
+func (qc *QQClient) handleGroupInvited(_ *client.QQClient, evt *event.GroupInvite) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group invite event")
+
+	// Invites from friends to small groups are accepted automatically,
+	// otherwise the room is created once the join event arrives.
+	if err := qc.Client.RefreshAllGroupsInfo(); err != nil {
+		qc.UserLogin.Log.Warn().Err(err).Msg("Failed to refresh groups")
+		return
+	}
+	if qc.Client.GetCachedGroupInfo(evt.GroupUin) == nil {
+		qc.UserLogin.Log.Info().
+			Uint32("group_uin", evt.GroupUin).
+			Uint32("invitor_uin", evt.InvitorUin).
+			Msg("Invited to QQ group, waiting for the invite to be accepted")
+		return
+	}
+	if err := qc.Client.RefreshGroupMembersCache(evt.GroupUin); err != nil {
+		qc.UserLogin.Log.Warn().Err(err).Uint32("group_uin", evt.GroupUin).Msg("Failed to refresh group members")
+	}
+
+	qc.queueGroupResync(evt.GroupUin, "invite")
+}
+
+func (qc *QQClient) handleGroupLeave(_ *client.QQClient, evt *event.GroupMemberDecrease) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group leave event")
+
+	sender := qc.selfEventSender()
+	if evt.IsKicked() && evt.OperatorUin != 0 {
+		sender = qc.makeEventSender(fmt.Sprint(evt.OperatorUin))
+	}
+
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.ChatDelete{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventChatDelete,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.Bool("kicked", evt.IsKicked())
+			},
+			PortalKey: qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(evt.GroupUin)),
+			Sender:    sender,
+			Timestamp: time.Now(),
+		},
+		OnlyForMe: true,
+	})
+}
+
+func (qc *QQClient) queueGroupResync(groupUin uint32, reason string) {
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.ChatResync{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventChatResync,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.Str("sync_reason", reason)
+			},
+			PortalKey:    qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(groupUin)),
+			CreatePortal: true,
+		},
+		GetChatInfoFunc: qc.getGroupChatInfo,
 	})
 }
+
+func (qc *QQClient) handleGroupMemberJoin(_ *client.QQClient, evt *event.GroupMemberIncrease) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group member join event")
+
+	member := qc.makeEventSender(fmt.Sprint(evt.UserUin))
+	sender := member
+	if evt.InvitorUin != 0 {
+		sender = qc.makeEventSender(fmt.Sprint(evt.InvitorUin))
+	}
+
+	qc.departedMembers.Delete(fmt.Sprintf("%d:%d", evt.GroupUin, evt.UserUin))
+	qc.queueMemberChange(evt.GroupUin, sender, bridgev2.ChatMember{
+		EventSender: member,
+		Membership:  mxevent.MembershipJoin,
+		PowerLevel:  ptr.Ptr(powerDefault),
+	})
+
+	// New members aren't in the member cache yet, fetching them takes a round trip
+	go qc.refreshJoinedMember(evt.GroupUin, evt.UserUin)
+}
+
+func (qc *QQClient) refreshJoinedMember(groupUin, uin uint32) {
+	log := qc.UserLogin.Log.With().Uint32("group_uin", groupUin).Uint32("member_uin", uin).Logger()
+	ctx := log.WithContext(context.Background())
+
+	cli := qc.Client
+	if cli == nil {
+		return
+	}
+	if err := cli.RefreshGroupMemberCache(groupUin, uin); err != nil {
+		log.Warn().Err(err).Msg("Failed to refresh joined group member")
+		return
+	}
+
+	portal, err := qc.Main.Bridge.GetExistingPortalByKey(ctx, qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(groupUin)))
+	if err != nil || portal == nil || portal.MXID == "" {
+		return
+	}
+	qc.updateSingleMemberDisplayname(ctx, portal, groupUin, uin)
+}
+
+func (qc *QQClient) handleGroupMemberLeave(_ *client.QQClient, evt *event.GroupMemberDecrease) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group member leave event")
+
+	// LagrangeGo never drops departed members from its cache, and later full member lists
+	// built from the cache would bring them back. They are skipped until the cache is refreshed.
+	qc.departedMembers.Store(fmt.Sprintf("%d:%d", evt.GroupUin, evt.UserUin), time.Now())
+	qc.scheduleMembersRefresh(evt.GroupUin)
+
+	member := qc.makeEventSender(fmt.Sprint(evt.UserUin))
+	sender := member
+	if evt.IsKicked() && evt.OperatorUin != 0 {
+		sender = qc.makeEventSender(fmt.Sprint(evt.OperatorUin))
+	}
+
+	qc.queueMemberChange(evt.GroupUin, sender, bridgev2.ChatMember{
+		EventSender:    member,
+		Membership:     mxevent.MembershipLeave,
+		PrevMembership: mxevent.MembershipJoin,
+	})
+}
+
+// Leaves within this delay share one member cache refresh, e.g. when many members are kicked at once
+const membersRefreshDelay = 10 * time.Second
+
+// scheduleMembersRefresh refreshes the member cache of a group in the background after the last leaves.
+func (qc *QQClient) scheduleMembersRefresh(groupUin uint32) {
+	if _, pending := qc.memberRefreshes.LoadOrStore(groupUin, struct{}{}); pending {
+		return
+	}
+
+	time.AfterFunc(membersRefreshDelay, func() {
+		qc.memberRefreshes.Delete(groupUin)
+
+		cli := qc.Client
+		if cli == nil {
+			return
+		}
+		started := time.Now()
+		if err := cli.RefreshGroupMembersCache(groupUin); err != nil {
+			qc.UserLogin.Log.Warn().Err(err).Uint32("group_uin", groupUin).Msg("Failed to refresh group members")
+			return
+		}
+
+		// Members that left during the refresh may still be in it
+		prefix := fmt.Sprintf("%d:", groupUin)
+		qc.departedMembers.Range(func(key, value any) bool {
+			if strings.HasPrefix(key.(string), prefix) && value.(time.Time).Before(started) {
+				qc.departedMembers.Delete(key)
+			}
+			return true
+		})
+	})
+}
+
+// isDepartedMember reports whether a member left the group but may still be in the member cache.
+func (qc *QQClient) isDepartedMember(groupUin, uin uint32) bool {
+	_, departed := qc.departedMembers.Load(fmt.Sprintf("%d:%d", groupUin, uin))
+	return departed
+}
+
+func (qc *QQClient) queueMemberChange(groupUin uint32, sender bridgev2.EventSender, member bridgev2.ChatMember) {
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.ChatInfoChange{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventChatInfoChange,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.Str("member_id", string(member.Sender)).Str("membership", string(member.Membership))
+			},
+			PortalKey: qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(groupUin)),
+			Sender:    sender,
+			Timestamp: time.Now(),
+		},
+		ChatInfoChange: &bridgev2.ChatInfoChange{
+			MemberChanges: &bridgev2.ChatMemberList{
+				MemberMap: map[networkid.UserID]bridgev2.ChatMember{
+					member.Sender: member,
+				},
+			},
+		},
+	})
+}
+
+func (qc *QQClient) handleGroupMute(_ *client.QQClient, evt *event.GroupMute) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group mute event")
+
+	sender := qc.makeEventSender(fmt.Sprint(evt.OperatorUin))
+
+	// An empty target means the whole group is (un)muted
+	if evt.UserUID == "" {
+		muteAll := evt.Duration != 0
+		eventsDefault := powerDefault
+		if muteAll {
+			eventsDefault = powerAdmin
+		}
+
+		qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.ChatInfoChange{
+			EventMeta: simplevent.EventMeta{
+				Type: bridgev2.RemoteEventChatInfoChange,
+				LogContext: func(c zerolog.Context) zerolog.Context {
+					return c.Bool("mute_all", muteAll)
+				},
+				PortalKey: qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(evt.GroupUin)),
+				Sender:    sender,
+				Timestamp: time.Now(),
+			},
+			ChatInfoChange: &bridgev2.ChatInfoChange{
+				ChatInfo: &bridgev2.ChatInfo{
+					ExtraUpdates: updateMuteAll(muteAll),
+				},
+				MemberChanges: &bridgev2.ChatMemberList{
+					PowerLevels: &bridgev2.PowerLevelOverrides{
+						EventsDefault: ptr.Ptr(eventsDefault),
+					},
+				},
+			},
+		})
+		return
+	}
+
+	if evt.Duration == 0 {
+		qc.cancelUnmute(evt.GroupUin, evt.UserUin)
+		qc.queueMemberPowerLevel(evt.GroupUin, evt.UserUin, sender, qc.memberPowerLevel(evt.GroupUin, evt.UserUin))
+	} else {
+		qc.scheduleUnmute(evt.GroupUin, evt.UserUin, time.Duration(evt.Duration)*time.Second)
+		qc.queueMemberPowerLevel(evt.GroupUin, evt.UserUin, sender, powerMuted)
+	}
+}
+
+func (qc *QQClient) queueMemberPowerLevel(groupUin, uin uint32, sender bridgev2.EventSender, pl int) {
+	member := qc.makeEventSender(fmt.Sprint(uin))
+
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.ChatInfoChange{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventChatInfoChange,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.Str("member_id", string(member.Sender)).Int("power_level", pl)
+			},
+			PortalKey: qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(groupUin)),
+			Sender:    sender,
+			Timestamp: time.Now(),
+		},
+		ChatInfoChange: &bridgev2.ChatInfoChange{
+			MemberChanges: &bridgev2.ChatMemberList{
+				MemberMap: map[networkid.UserID]bridgev2.ChatMember{
+					member.Sender: {
+						EventSender: member,
+						Membership:  mxevent.MembershipJoin,
+						PowerLevel:  ptr.Ptr(pl),
+					},
+				},
+			},
+		},
+	})
+}
+
+// memberPowerLevel returns the power level of an unmuted group member.
+func (qc *QQClient) memberPowerLevel(groupUin, uin uint32) int {
+	if member := qc.Client.GetCachedMemberInfo(uin, groupUin); member != nil {
+		return permissionToPowerLevel(member.Permission)
+	}
+	return powerDefault
+}
+
+// scheduleUnmute restores the power level of a muted member once the mute expires.
+func (qc *QQClient) scheduleUnmute(groupUin, uin uint32, duration time.Duration) {
+	key := fmt.Sprintf("%d:%d", groupUin, uin)
+
+	qc.muteTimersLock.Lock()
+	defer qc.muteTimersLock.Unlock()
+
+	if timer, exists := qc.muteTimers[key]; exists {
+		timer.Stop()
+	}
+	qc.muteTimers[key] = time.AfterFunc(duration, func() {
+		qc.muteTimersLock.Lock()
+		delete(qc.muteTimers, key)
+		qc.muteTimersLock.Unlock()
+
+		qc.queueMemberPowerLevel(groupUin, uin, qc.makeEventSender(fmt.Sprint(uin)), qc.memberPowerLevel(groupUin, uin))
+	})
+}
+
+// scheduleMutedMembers schedules unmutes for all members of a group that are currently muted.
+func (qc *QQClient) scheduleMutedMembers(groupUin uint32) {
+	now := time.Now()
+	for _, m := range qc.Client.GetCachedMembersInfo(groupUin) {
+		if remaining := muteRemaining(m.ShutUpTime, now); remaining > 0 {
+			qc.scheduleUnmute(groupUin, m.Uin, remaining)
+		}
+	}
+}
+
+// muteRemaining returns how long a mute ending at the unix time shutUpTime still lasts, or 0 if it's over.
+func muteRemaining(shutUpTime uint32, now time.Time) time.Duration {
+	return max(time.Unix(int64(shutUpTime), 0).Sub(now), 0)
+}
+
+func (qc *QQClient) cancelUnmute(groupUin, uin uint32) {
+	key := fmt.Sprintf("%d:%d", groupUin, uin)
+
+	qc.muteTimersLock.Lock()
+	defer qc.muteTimersLock.Unlock()
+
+	if timer, exists := qc.muteTimers[key]; exists {
+		timer.Stop()
+		delete(qc.muteTimers, key)
+	}
+}
+
+func (qc *QQClient) handleNewFriendRequest(_ *client.QQClient, evt *event.NewFriendRequest) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ new friend request event")
+
+	ctx := context.Background()
+	qc.setFriendRequest(ctx, evt.SourceUin, evt.SourceUID)
+
+	// The nickname, source and message are controlled by the requester, so they are only ever escaped
+	body := fmt.Sprintf("%s (%d) wants to be your QQ friend", evt.SourceNick, evt.SourceUin)
+	formatted := fmt.Sprintf("%s (%d) wants to be your QQ friend", html.EscapeString(evt.SourceNick), evt.SourceUin)
+	if evt.Source != "" {
+		body += fmt.Sprintf(" (from %s)", evt.Source)
+		formatted += fmt.Sprintf(" (from %s)", html.EscapeString(evt.Source))
+	}
+	if evt.Msg != "" {
+		body += ":\n\n" + quoteText(evt.Msg)
+		formatted += ":" + quoteHTML(evt.Msg)
+	}
+	body, formatted = appendUsage(body, formatted,
+		fmt.Sprintf("%s accept-friend %d", qc.Main.Bridge.Config.CommandPrefix, evt.SourceUin),
+		fmt.Sprintf("%s reject-friend %d", qc.Main.Bridge.Config.CommandPrefix, evt.SourceUin),
+	)
+
+	qc.sendManagementNotice(ctx, body, formatted)
+}
+
+func (qc *QQClient) handleNewFriend(_ *client.QQClient, evt *event.NewFriend) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ new friend event")
+
+	qc.setFriendRequest(context.Background(), evt.FromUin, "")
+	if err := qc.Client.RefreshFriendCache(); err != nil {
+		qc.UserLogin.Log.Warn().Err(err).Msg("Failed to refresh friends")
+	}
+}
+
+// setFriendRequest stores a pending friend request in the login metadata, an empty UID removes it.
+func (qc *QQClient) setFriendRequest(ctx context.Context, uin uint32, uid string) {
+	qc.friendRequestsLock.Lock()
+	defer qc.friendRequestsLock.Unlock()
+
+	meta := qc.UserLogin.Metadata.(*qqid.UserLoginMetadata)
+	if uid == "" {
+		if _, ok := meta.FriendRequests[uin]; !ok {
+			return
+		}
+		delete(meta.FriendRequests, uin)
+	} else {
+		if meta.FriendRequests == nil {
+			meta.FriendRequests = make(map[uint32]string)
+		}
+		meta.FriendRequests[uin] = uid
+	}
+
+	if err := qc.UserLogin.Save(ctx); err != nil {
+		qc.UserLogin.Log.Err(err).Msg("Failed to save pending friend requests")
+	}
+}
+
+func (qc *QQClient) getFriendRequest(uin uint32) (string, bool) {
+	qc.friendRequestsLock.Lock()
+	defer qc.friendRequestsLock.Unlock()
+
+	uid, ok := qc.UserLogin.Metadata.(*qqid.UserLoginMetadata).FriendRequests[uin]
+	return uid, ok
+}
+
+// answerFriendRequest accepts or rejects a pending friend request.
+// It returns false if there is no pending request from the user.
+func (qc *QQClient) answerFriendRequest(ctx context.Context, uin uint32, accept bool) (bool, error) {
+	uid, ok := qc.getFriendRequest(uin)
+	if !ok {
+		return false, nil
+	}
+
+	if err := qc.Client.SetFriendRequest(accept, uid); err != nil {
+		return true, err
+	}
+	qc.setFriendRequest(ctx, uin, "")
+
+	if accept {
+		if err := qc.Client.RefreshFriendCache(); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to refresh friends")
+		}
+
+		recipient := fmt.Sprint(uin)
+		qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &simplevent.ChatResync{
+			EventMeta: simplevent.EventMeta{
+				Type: bridgev2.RemoteEventChatResync,
+				LogContext: func(c zerolog.Context) zerolog.Context {
+					return c.Str("sync_reason", "friend request")
+				},
+				PortalKey:    qc.makeDMPortalKey(recipient),
+				CreatePortal: true,
+			},
+			GetChatInfoFunc: func(ctx context.Context, portal *bridgev2.Portal) (*bridgev2.ChatInfo, error) {
+				return qc.getDirectChatInfo(recipient)
+			},
+		})
+	}
+
+	return true, nil
+}
+
+func (qc *QQClient) sendManagementNotice(ctx context.Context, body, formatted string) {
+	roomID, err := qc.UserLogin.User.GetManagementRoom(ctx)
+	if err != nil {
+		qc.UserLogin.Log.Err(err).Msg("Failed to get management room")
+		return
+	}
+
+	qc.sendBotNotice(ctx, roomID, body, formatted)
+}
+
+func (qc *QQClient) sendBotNotice(ctx context.Context, roomID id.RoomID, body, formatted string) {
+	if _, err := qc.Main.Bridge.Bot.SendMessage(ctx, roomID, mxevent.EventMessage, &mxevent.Content{
+		Parsed: &mxevent.MessageEventContent{
+			MsgType:       mxevent.MsgNotice,
+			Body:          body,
+			Format:        mxevent.FormatHTML,
+			FormattedBody: formatted,
+		},
+	}, nil); err != nil {
+		qc.UserLogin.Log.Err(err).Stringer("room_id", roomID).Msg("Failed to send bot notice")
+	}
+}
+
+// quoteText quotes every line of text for a plain text body.
+func quoteText(text string) string {
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
+func quoteHTML(text string) string {
+	return "<blockquote>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>") + "</blockquote>"
+}
+
+// appendUsage appends the commands to answer a request to a notice.
+func appendUsage(body, formatted, accept, reject string) (string, string) {
+	body += fmt.Sprintf("\n\nUse `%s` or `%s` to answer.", accept, reject)
+	formatted += fmt.Sprintf("<br><br>Use <code>%s</code> or <code>%s</code> to answer.", html.EscapeString(accept), html.EscapeString(reject))
+	return body, formatted
+}
+
+func (qc *QQClient) handleGroupMemberJoinRequest(_ *client.QQClient, evt *event.GroupMemberJoinRequest) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group member join request event")
+
+	// Only admins can act on join requests
+	if evt.RequestSeq == 0 || !qc.isGroupAdmin(evt.GroupUin) {
+		return
+	}
+
+	ctx := context.Background()
+	portal, err := qc.Main.Bridge.GetExistingPortalByKey(ctx, qc.makePortalKey(qqid.ChatGroup, fmt.Sprint(evt.GroupUin)))
+	if err != nil {
+		qc.UserLogin.Log.Err(err).Uint32("group_uin", evt.GroupUin).Msg("Failed to get portal")
+		return
+	} else if portal == nil || portal.MXID == "" {
+		return
+	}
+
+	// Every admin login in the group receives the request, only one of them posts it
+	if !qc.isNoticeLogin(ctx, portal.PortalKey, evt.GroupUin) {
+		return
+	}
+
+	// The nickname and answer are controlled by the requester, so they are only ever escaped
+	body := fmt.Sprintf("%s (%d) requested to join the group", evt.TargetNick, evt.UserUin)
+	formatted := fmt.Sprintf("%s (%d) requested to join the group", html.EscapeString(evt.TargetNick), evt.UserUin)
+	if evt.InvitorUin != 0 {
+		body += fmt.Sprintf(", invited by %d", evt.InvitorUin)
+		formatted += fmt.Sprintf(", invited by %d", evt.InvitorUin)
+	}
+	if evt.Answer != "" {
+		body += ":\n\n" + quoteText(evt.Answer)
+		formatted += ":" + quoteHTML(evt.Answer)
+	}
+	body, formatted = appendUsage(body, formatted,
+		fmt.Sprintf("%s approve %d", qc.Main.Bridge.Config.CommandPrefix, evt.RequestSeq),
+		fmt.Sprintf("%s reject %d [reason]", qc.Main.Bridge.Config.CommandPrefix, evt.RequestSeq),
+	)
+
+	qc.sendBotNotice(ctx, portal.MXID, body, formatted)
+}
+
+// isNoticeLogin reports whether this login is the one that posts group notices
+// meant for admins, which is the logged in admin login with the lowest ID.
+func (qc *QQClient) isNoticeLogin(ctx context.Context, portalKey networkid.PortalKey, groupUin uint32) bool {
+	logins, err := qc.Main.Bridge.GetUserLoginsInPortal(ctx, portalKey)
+	if err != nil {
+		qc.UserLogin.Log.Err(err).Uint32("group_uin", groupUin).Msg("Failed to get logins in portal")
+		return true
+	}
+
+	for _, login := range logins {
+		if login.ID >= qc.UserLogin.ID {
+			continue
+		}
+		if other, ok := login.Client.(*QQClient); ok && other.IsLoggedIn() && other.isGroupAdmin(groupUin) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// answerJoinRequest approves or rejects a pending join request of a group.
+// It returns false if there is no such request.
+func (qc *QQClient) answerJoinRequest(groupUin uint32, seq uint64, accept bool, reason string) (bool, error) {
+	// System messages of all groups can only be fetched newest first without paging,
+	// so older requests are looked for with growing limits
+	for _, count := range joinRequestFetchCounts {
+		for _, filtered := range []bool{false, true} {
+			msgs, err := qc.Client.GetGroupSystemMessages(filtered, count, groupUin)
+			if err != nil {
+				return false, fmt.Errorf("failed to fetch join requests: %w", err)
+			}
+
+			for _, req := range msgs.JoinRequests {
+				if req.Sequence != seq || req.Checked {
+					continue
+				}
+				if err := qc.Client.SetGroupRequest(req.IsFiltered, accept, req.Sequence, uint32(req.EventType), groupUin, reason); err != nil {
+					return true, err
+				}
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+var joinRequestFetchCounts = []uint32{20, 100, 500}
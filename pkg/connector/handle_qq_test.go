@@ -0,0 +1,31 @@
+package connector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMuteRemaining(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		shutUpTime uint32
+		want       time.Duration
+	}{
+		{0, 0},
+		{1699999999, 0},
+		{1700000000, 0},
+		{1700000001, time.Second},
+		{1700000600, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := muteRemaining(tt.shutUpTime, now); got != tt.want {
+			t.Errorf("muteRemaining(%d) = %s, want %s", tt.shutUpTime, got, tt.want)
+		}
+	}
+
+	if got := muteRemaining(1700000000, now.Add(500*time.Millisecond)); got != 0 {
+		t.Errorf("muteRemaining() of a mute that just ended = %s, want 0", got)
+	}
+}
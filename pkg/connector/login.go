@@ -2,7 +2,10 @@ package connector
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/duo/matrix-qq/pkg/qqid"
@@ -17,40 +20,84 @@ import (
 )
 
 const (
-	LoginStepQR       = "me.lxduo.qq.login.qr"
-	LoginStepComplete = "me.lxduo.qq.login.complete"
+	LoginStepQR          = "me.lxduo.qq.login.qr"
+	LoginStepQRScanned   = "me.lxduo.qq.login.qr_scanned"
+	LoginStepCredentials = "me.lxduo.qq.login.credentials"
+	LoginStepCaptcha     = "me.lxduo.qq.login.captcha"
+	LoginStepDeviceLock  = "me.lxduo.qq.login.device_lock"
+	LoginStepComplete    = "me.lxduo.qq.login.complete"
 )
 
+var ErrSMSLoginUnsupported = errors.New("this account requires an SMS code, which is not supported by password login, log in with a QR code instead")
+
 type QRLogin struct {
 	User   *bridgev2.User
 	Main   *QQConnector
 	Client *client.QQClient
 	Log    zerolog.Logger
+
+	refreshes uint
+	scanned   bool
+}
+
+type PasswordLogin struct {
+	User   *bridgev2.User
+	Main   *QQConnector
+	Client *client.QQClient
+	Log    zerolog.Logger
+
+	captchaAid string
+	verifyURL  string
+
+	verify *deviceVerification
 }
 
-var _ bridgev2.LoginProcessDisplayAndWait = (*QRLogin)(nil)
+// deviceVerification is a running new device verification, done is closed once it's over.
+type deviceVerification struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	_ bridgev2.LoginProcessDisplayAndWait = (*QRLogin)(nil)
+	_ bridgev2.LoginProcessUserInput      = (*PasswordLogin)(nil)
+	_ bridgev2.LoginProcessDisplayAndWait = (*PasswordLogin)(nil)
+)
 
 func (qc *QQConnector) GetLoginFlows() []bridgev2.LoginFlow {
 	return []bridgev2.LoginFlow{{
 		Name:        "QR",
 		Description: "Scan a QR code to pair the bridge to your QQ client",
 		ID:          "qr",
+	}, {
+		Name:        "Password",
+		Description: "Log in with your QQ number and password, accounts that require an SMS code have to use QR login",
+		ID:          "password",
 	}}
 }
 
 func (qc *QQConnector) CreateLogin(ctx context.Context, user *bridgev2.User, flowID string) (bridgev2.LoginProcess, error) {
-	if flowID != "qr" {
+	log := user.Log.With().
+		Str("action", "login").
+		Stringer("user_id", user.MXID).
+		Logger()
+
+	switch flowID {
+	case "qr":
+		return &QRLogin{
+			User: user,
+			Main: qc,
+			Log:  log,
+		}, nil
+	case "password":
+		return &PasswordLogin{
+			User: user,
+			Main: qc,
+			Log:  log,
+		}, nil
+	default:
 		return nil, fmt.Errorf("invalid login flow ID")
 	}
-
-	return &QRLogin{
-		User: user,
-		Main: qc,
-		Log: user.Log.With().
-			Str("action", "login").
-			Stringer("user_id", user.MXID).
-			Logger(),
-	}, nil
 }
 
 func (qr *QRLogin) Cancel() {
@@ -65,10 +112,15 @@ func (qr *QRLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
 		qr.Main.Config.SignServers,
 	)
 
+	return qr.fetchQRCode()
+}
+
+func (qr *QRLogin) fetchQRCode() (*bridgev2.LoginStep, error) {
 	_, qrcode, err := qr.Client.FetchQRCode(1, 2, 1)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch QR code: %w", err)
 	}
+	qr.scanned = false
 
 	return &bridgev2.LoginStep{
 		Type:         bridgev2.LoginStepTypeDisplayAndWait,
@@ -99,9 +151,25 @@ func (qr *QRLogin) Wait(ctx context.Context) (*bridgev2.LoginStep, error) {
 				case qrcodestate.Canceled:
 					return nil, fmt.Errorf("scanning QR code was canceled by the user")
 				case qrcodestate.Expired:
-					return nil, fmt.Errorf("QR code expired")
+					if qr.refreshes >= qr.Main.Config.QRMaxRefreshes {
+						return nil, fmt.Errorf("QR code expired")
+					}
+					qr.refreshes++
+					qr.Log.Debug().Uint("refreshes", qr.refreshes).Msg("QR code expired, fetching a new one")
+					return qr.fetchQRCode()
 				case qrcodestate.WaitingForScan:
 				case qrcodestate.WaitingForConfirm:
+					if !qr.scanned {
+						qr.scanned = true
+						return &bridgev2.LoginStep{
+							Type:         bridgev2.LoginStepTypeDisplayAndWait,
+							StepID:       LoginStepQRScanned,
+							Instructions: "QR code scanned, confirm the login on your phone",
+							DisplayAndWaitParams: &bridgev2.LoginDisplayAndWaitParams{
+								Type: bridgev2.LoginDisplayTypeNothing,
+							},
+						}, nil
+					}
 				case qrcodestate.Confirmed:
 					if res, err := qr.Client.QRCodeLogin(); err != nil {
 						return nil, fmt.Errorf("failed to login through QR code: %w", err)
@@ -110,37 +178,7 @@ func (qr *QRLogin) Wait(ctx context.Context) (*bridgev2.LoginStep, error) {
 							return nil, fmt.Errorf("Error code: %d, message: %s", res.Code, res.ErrorMessage)
 						}
 
-						uin := qr.Client.Uin
-						name := qr.Client.NickName()
-						device := qr.Client.Device()
-						token, _ := qr.Client.Sig().Marshal()
-						qr.Client.Release()
-
-						ul, err := qr.User.NewLogin(ctx, &database.UserLogin{
-							ID:         qqid.MakeUserLoginID(fmt.Sprint(uin)),
-							RemoteName: name,
-							Metadata: &qqid.UserLoginMetadata{
-								Device: device,
-								Token:  token,
-							},
-						}, &bridgev2.NewLoginParams{
-							DeleteOnConflict: true,
-						})
-						if err != nil {
-							return nil, fmt.Errorf("failed to create user login: %w", err)
-						}
-
-						ul.Client.Connect(ul.Log.WithContext(context.Background()))
-
-						return &bridgev2.LoginStep{
-							Type:         bridgev2.LoginStepTypeComplete,
-							StepID:       LoginStepComplete,
-							Instructions: fmt.Sprintf("Successfully logged in as %s", ul.RemoteName),
-							CompleteParams: &bridgev2.LoginCompleteParams{
-								UserLoginID: ul.ID,
-								UserLogin:   ul,
-							},
-						}, nil
+						return qr.Main.finishLogin(ctx, qr.User, qr.Client)
 					}
 				}
 			}
@@ -149,10 +187,220 @@ func (qr *QRLogin) Wait(ctx context.Context) (*bridgev2.LoginStep, error) {
 	}
 }
 
+func (pl *PasswordLogin) Cancel() {
+	pl.releaseClient()
+}
+
+// releaseClient releases the login client. While device verification is still
+// polling with it, the client is released once the verification gives up.
+func (pl *PasswordLogin) releaseClient() {
+	cli, verify := pl.Client, pl.verify
+	pl.Client, pl.verifyURL, pl.verify = nil, "", nil
+	if cli == nil {
+		return
+	}
+
+	if verify != nil {
+		go func() {
+			<-verify.done
+			cli.Release()
+		}()
+		return
+	}
+	cli.Release()
+}
+
+func (pl *PasswordLogin) Start(ctx context.Context) (*bridgev2.LoginStep, error) {
+	return &bridgev2.LoginStep{
+		Type:         bridgev2.LoginStepTypeUserInput,
+		StepID:       LoginStepCredentials,
+		Instructions: "Enter your QQ number and password",
+		UserInputParams: &bridgev2.LoginUserInputParams{
+			Fields: []bridgev2.LoginInputDataField{{
+				Type:    bridgev2.LoginInputFieldTypeUsername,
+				ID:      "uin",
+				Name:    "QQ number",
+				Pattern: `^[0-9]{5,11}$`,
+			}, {
+				Type: bridgev2.LoginInputFieldTypePassword,
+				ID:   "password",
+				Name: "Password",
+			}},
+		},
+	}, nil
+}
+
+func (pl *PasswordLogin) SubmitUserInput(ctx context.Context, input map[string]string) (*bridgev2.LoginStep, error) {
+	if ticket, ok := input["ticket"]; ok {
+		if pl.Client == nil {
+			return nil, fmt.Errorf("login not started")
+		}
+
+		res, err := pl.Client.SubmitCaptcha(ticket, input["rand_str"], pl.captchaAid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to submit captcha: %w", err)
+		}
+		return pl.handleResponse(ctx, res)
+	}
+
+	uin, err := strconv.ParseUint(input["uin"], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QQ number: %w", err)
+	}
+
+	// The credentials may be submitted again after a failed attempt
+	pl.releaseClient()
+	pl.Client = setupClient(
+		client.NewClient(uint32(uin), input["password"]),
+		pl.Main.Bridge.Log.With().Stringer("user_id", pl.User.MXID).Logger(),
+		auth.NewDeviceInfo(int(crypto.RandU32())),
+		pl.Main.Config.SignServers,
+	)
+
+	res, err := pl.Client.PasswordLogin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to login with password: %w", err)
+	}
+	return pl.handleResponse(ctx, res)
+}
+
+func (pl *PasswordLogin) handleResponse(ctx context.Context, res *client.LoginResponse) (*bridgev2.LoginStep, error) {
+	if res.Success {
+		return pl.Main.finishLogin(ctx, pl.User, pl.Client)
+	}
+
+	pl.Log.Debug().
+		Stringer("error", res.Error).
+		Str("message", res.ErrorMessage).
+		Msg("Password login needs further action")
+
+	switch res.Error {
+	case client.SliderNeededError, client.NeedCaptcha:
+		if parsed, err := url.Parse(res.VerifyURL); err == nil {
+			pl.captchaAid = parsed.Query().Get("sid")
+		}
+
+		return &bridgev2.LoginStep{
+			Type:   bridgev2.LoginStepTypeUserInput,
+			StepID: LoginStepCaptcha,
+			Instructions: fmt.Sprintf(
+				"Solve the slider captcha at %s and enter the ticket and randstr from the verification result",
+				res.VerifyURL,
+			),
+			UserInputParams: &bridgev2.LoginUserInputParams{
+				Fields: []bridgev2.LoginInputDataField{{
+					Type: bridgev2.LoginInputFieldTypeToken,
+					ID:   "ticket",
+					Name: "Ticket",
+				}, {
+					Type: bridgev2.LoginInputFieldTypeToken,
+					ID:   "rand_str",
+					Name: "Randstr",
+				}},
+			},
+		}, nil
+	case client.SMSNeededError:
+		// LagrangeGo has no way to request or submit SMS codes
+		return nil, ErrSMSLoginUnsupported
+	case client.UnsafeDeviceError, client.SMSOrVerifyNeededError:
+		// The device verification page can send and check an SMS code itself
+		verifyURL, err := pl.Client.GetNewDeviceVerifyURL()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get device verification URL: %w", err)
+		}
+		pl.verifyURL = verifyURL
+
+		instructions := "Scan the QR code with your QQ app to verify this new device"
+		if res.SMSPhone != "" {
+			instructions += fmt.Sprintf(", an SMS code may be sent to %s", res.SMSPhone)
+		}
+
+		return &bridgev2.LoginStep{
+			Type:         bridgev2.LoginStepTypeDisplayAndWait,
+			StepID:       LoginStepDeviceLock,
+			Instructions: instructions,
+			DisplayAndWaitParams: &bridgev2.LoginDisplayAndWaitParams{
+				Type: bridgev2.LoginDisplayTypeQR,
+				Data: verifyURL,
+			},
+		}, nil
+	case client.TooManySMSRequestError:
+		return nil, fmt.Errorf("too many SMS requests, try again later")
+	default:
+		return nil, fmt.Errorf("Error code: %d, message: %s", res.Code, res.ErrorMessage)
+	}
+}
+
+func (pl *PasswordLogin) Wait(ctx context.Context) (*bridgev2.LoginStep, error) {
+	if pl.Client == nil || pl.verifyURL == "" {
+		return nil, fmt.Errorf("device verification not started")
+	}
+
+	// NewDeviceVerify polls for up to 2 minutes and can't be cancelled, so it runs
+	// in the background and Cancel keeps the client alive until it's done
+	if pl.verify == nil {
+		cli, verifyURL, verify := pl.Client, pl.verifyURL, &deviceVerification{done: make(chan struct{})}
+		pl.verify = verify
+		go func() {
+			defer close(verify.done)
+			verify.err = cli.NewDeviceVerify(verifyURL)
+		}()
+	}
+
+	verify := pl.verify
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-verify.done:
+		if verify.err != nil {
+			return nil, fmt.Errorf("failed to verify new device: %w", verify.err)
+		}
+	}
+
+	return pl.Main.finishLogin(ctx, pl.User, pl.Client)
+}
+
+func (qc *QQConnector) finishLogin(ctx context.Context, user *bridgev2.User, cli *client.QQClient) (*bridgev2.LoginStep, error) {
+	uin := cli.Uin
+	name := cli.NickName()
+	device := cli.Device()
+	token, _ := cli.Sig().Marshal()
+	cli.Release()
+
+	ul, err := user.NewLogin(ctx, &database.UserLogin{
+		ID:         qqid.MakeUserLoginID(fmt.Sprint(uin)),
+		RemoteName: name,
+		Metadata: &qqid.UserLoginMetadata{
+			Device: device,
+			Token:  token,
+		},
+	}, &bridgev2.NewLoginParams{
+		DeleteOnConflict: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user login: %w", err)
+	}
+
+	ul.Client.Connect(ul.Log.WithContext(context.Background()))
+
+	return &bridgev2.LoginStep{
+		Type:         bridgev2.LoginStepTypeComplete,
+		StepID:       LoginStepComplete,
+		Instructions: fmt.Sprintf("Successfully logged in as %s", ul.RemoteName),
+		CompleteParams: &bridgev2.LoginCompleteParams{
+			UserLoginID: ul.ID,
+			UserLogin:   ul,
+		},
+	}, nil
+}
+
 func newClient(log zerolog.Logger, device *auth.DeviceInfo, signUrls []string) *client.QQClient {
+	return setupClient(client.NewClientEmpty(), log, device, signUrls)
+}
+
+func setupClient(c *client.QQClient, log zerolog.Logger, device *auth.DeviceInfo, signUrls []string) *client.QQClient {
 	app := auth.AppList["linux"]["3.2.15-30366"]
 
-	c := client.NewClientEmpty()
 	c.UseVersion(app)
 	c.AddSignServer(signUrls...)
 	c.SetLogger(protocolLogger{log: log.With().Str("protocol", "qq").Logger()})
@@ -0,0 +1,245 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"time"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"github.com/LagrangeDev/LagrangeGo/client"
+	"github.com/LagrangeDev/LagrangeGo/client/event"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	mxevent "maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+type QQPokeEvent struct {
+	ChatType  qqid.ChatType
+	ChatID    string
+	Sender    uint32
+	Receiver  uint32
+	Suffix    string
+	Timestamp time.Time
+	qc        *QQClient
+}
+
+var (
+	_ bridgev2.RemoteEventThatMayCreatePortal = (*QQPokeEvent)(nil)
+	_ bridgev2.RemoteMessage                  = (*QQPokeEvent)(nil)
+	_ bridgev2.RemoteEventWithTimestamp       = (*QQPokeEvent)(nil)
+)
+
+func (evt *QQPokeEvent) ShouldCreatePortal() bool {
+	return false
+}
+
+func (evt *QQPokeEvent) AddLogContext(c zerolog.Context) zerolog.Context {
+	return c.Uint32("sender_id", evt.Sender).Uint32("receiver_id", evt.Receiver)
+}
+
+func (evt *QQPokeEvent) GetPortalKey() networkid.PortalKey {
+	return evt.qc.makePortalKey(evt.ChatType, evt.ChatID)
+}
+
+func (evt *QQPokeEvent) GetSender() bridgev2.EventSender {
+	return evt.qc.makeEventSender(fmt.Sprint(evt.Sender))
+}
+
+// GetID is built from the poke itself, so logins sharing a group produce the same ID and the poke is bridged once.
+func (evt *QQPokeEvent) GetID() networkid.MessageID {
+	return qqid.MakeFakeMessageID(evt.ChatID, fmt.Sprintf("poke-%d-%d-%d", evt.Sender, evt.Receiver, evt.Timestamp.UnixMilli()))
+}
+
+func (evt *QQPokeEvent) GetTimestamp() time.Time {
+	return evt.Timestamp
+}
+
+func (evt *QQPokeEvent) GetType() bridgev2.RemoteEventType {
+	return bridgev2.RemoteEventMessage
+}
+
+func (evt *QQPokeEvent) ConvertMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI) (*bridgev2.ConvertedMessage, error) {
+	content := &mxevent.MessageEventContent{
+		MsgType:  mxevent.MsgEmote,
+		Mentions: &mxevent.Mentions{},
+	}
+
+	receiver := fmt.Sprint(evt.Receiver)
+	if evt.Receiver == evt.Sender {
+		content.Body = "poked themselves"
+		content.FormattedBody = html.EscapeString(content.Body)
+	} else if mxid, name, err := evt.qc.getPokeTarget(ctx, evt.ChatType, evt.ChatID, receiver); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Str("receiver_id", receiver).Msg("Failed to get poke receiver info")
+		content.Body = "poked " + receiver
+		content.FormattedBody = html.EscapeString(content.Body)
+	} else {
+		content.Mentions.UserIDs = append(content.Mentions.UserIDs, mxid)
+		content.Body = "poked " + name
+		content.FormattedBody = fmt.Sprintf(`poked <a href="%s">%s</a>`, mxid.URI().MatrixToURL(), html.EscapeString(name))
+	}
+	if evt.Suffix != "" {
+		content.Body += " " + evt.Suffix
+		content.FormattedBody += " " + html.EscapeString(evt.Suffix)
+	}
+	content.Format = mxevent.FormatHTML
+
+	return &bridgev2.ConvertedMessage{
+		Parts: []*bridgev2.ConvertedMessagePart{{
+			Type:    mxevent.EventMessage,
+			Content: content,
+		}},
+	}, nil
+}
+
+// getPokeTarget returns the Matrix user and name to mention for a poked QQ user.
+func (qc *QQClient) getPokeTarget(ctx context.Context, chatType qqid.ChatType, chatID string, uin string) (mxid id.UserID, name string, err error) {
+	ghost, err := qc.Main.Bridge.GetGhostByID(ctx, qqid.MakeUserID(uin))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get ghost by ID: %w", err)
+	}
+
+	mxid = ghost.Intent.GetMXID()
+	if login := qc.Main.Bridge.GetCachedUserLoginByID(qqid.MakeUserLoginID(uin)); login != nil {
+		mxid = login.UserMXID
+	}
+
+	return mxid, qc.getMemberName(chatType, chatID, uin, ghost.Name), nil
+}
+
+// getMemberName prefers the group card of a user over the given fallback name.
+func (qc *QQClient) getMemberName(chatType qqid.ChatType, chatID string, uin string, fallback string) string {
+	name := fallback
+	if chatType == qqid.ChatGroup {
+		if groupUin, err := strconv.ParseUint(chatID, 10, 32); err == nil {
+			if userUin, err := strconv.ParseUint(uin, 10, 32); err == nil {
+				if member := qc.Client.GetCachedMemberInfo(uint32(userUin), uint32(groupUin)); member != nil {
+					name = member.DisplayName()
+				}
+			}
+		}
+	}
+	if name == "" {
+		name = uin
+	}
+
+	return name
+}
+
+func (qc *QQClient) handleFriendPoke(_ *client.QQClient, evt event.INotifyEvent) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ friend notify event")
+
+	poke, ok := evt.(*event.FriendPokeEvent)
+	if !ok || poke.Sender == 0 {
+		return
+	}
+
+	// The portal is the DM with whoever isn't us
+	peer := poke.Sender
+	if peer == qc.Client.Uin {
+		peer = poke.Receiver
+	}
+
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &QQPokeEvent{
+		ChatType:  qqid.ChatPrivate,
+		ChatID:    fmt.Sprint(peer),
+		Sender:    poke.Sender,
+		Receiver:  poke.Receiver,
+		Suffix:    poke.Suffix,
+		Timestamp: time.Now(),
+		qc:        qc,
+	})
+}
+
+func (qc *QQClient) handleGroupPoke(_ *client.QQClient, evt event.INotifyEvent) {
+	qc.UserLogin.Log.Trace().Any("event", evt).Msg("Receive QQ group notify event")
+
+	poke, ok := evt.(*event.GroupPokeEvent)
+	if !ok || poke.UserUin == 0 {
+		return
+	}
+
+	qc.Main.Bridge.QueueRemoteEvent(qc.UserLogin, &QQPokeEvent{
+		ChatType:  qqid.ChatGroup,
+		ChatID:    fmt.Sprint(poke.GroupUin),
+		Sender:    poke.UserUin,
+		Receiver:  poke.Receiver,
+		Suffix:    poke.Suffix,
+		Timestamp: qc.Main.pokeTime(qc.UserLogin.ID, fmt.Sprintf("%d:%d:%d", poke.GroupUin, poke.UserUin, poke.Receiver), time.Now()),
+		qc:        qc,
+	})
+}
+
+// Pokes received by several logins within this window are the same poke
+const pokeDedupWindow = 10 * time.Second
+
+type recentPoke struct {
+	// When each repeat of the poke was first received
+	times []time.Time
+	// How many repeats each login has received
+	seen map[networkid.UserLoginID]int
+}
+
+// pokeTime returns when a poke was first received by any login. QQ poke events carry
+// no time, so this is what lets every login agree on the event ID of the same poke.
+// The nth time a login receives the same poke, it gets the time of the nth repeat,
+// so repeated pokes still get their own IDs.
+func (qc *QQConnector) pokeTime(loginID networkid.UserLoginID, key string, now time.Time) time.Time {
+	qc.recentPokesLock.Lock()
+	defer qc.recentPokesLock.Unlock()
+
+	if qc.recentPokes == nil {
+		qc.recentPokes = make(map[string]*recentPoke)
+	}
+	for k, p := range qc.recentPokes {
+		if now.Sub(p.times[len(p.times)-1]) > pokeDedupWindow {
+			delete(qc.recentPokes, k)
+		}
+	}
+
+	p, ok := qc.recentPokes[key]
+	if !ok {
+		p = &recentPoke{seen: make(map[networkid.UserLoginID]int)}
+		qc.recentPokes[key] = p
+	}
+
+	n := p.seen[loginID]
+	p.seen[loginID] = n + 1
+	if n < len(p.times) {
+		return p.times[n]
+	}
+	p.times = append(p.times, now)
+	return now
+}
+
+// sendPoke pokes a user in the chat of the given portal.
+func (qc *QQClient) sendPoke(portal *bridgev2.Portal, uin uint32) error {
+	switch portal.Metadata.(*qqid.PortalMetadata).ChatType {
+	case qqid.ChatPrivate:
+		peer, err := strconv.ParseUint(string(portal.ID), 10, 32)
+		if err != nil {
+			return err
+		}
+		if uin == 0 {
+			uin = uint32(peer)
+		} else if uin != uint32(peer) {
+			return fmt.Errorf("only %d can be poked in this chat", peer)
+		}
+		return qc.Client.FriendPoke(uin)
+	case qqid.ChatGroup:
+		groupUin, err := strconv.ParseUint(string(portal.ID), 10, 32)
+		if err != nil {
+			return err
+		}
+		if uin == 0 {
+			return fmt.Errorf("a user to poke is required in groups")
+		}
+		return qc.Client.GroupPoke(uint32(groupUin), uin)
+	default:
+		return fmt.Errorf("poking is not supported in this chat")
+	}
+}
@@ -0,0 +1,79 @@
+package connector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+)
+
+// pokeCall is a poke received by login at now ms, expected to get the time of the poke at want ms.
+type pokeCall struct {
+	login string
+	key   string
+	now   int
+	want  int
+}
+
+func TestPokeTime(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	tests := []struct {
+		name  string
+		calls []pokeCall
+	}{
+		{"same poke on two logins", []pokeCall{
+			{"a", "1:2:3", 0, 0},
+			{"b", "1:2:3", 300, 0},
+		}},
+		{"repeated poke on one login", []pokeCall{
+			{"a", "1:2:3", 0, 0},
+			{"a", "1:2:3", 1000, 1000},
+			{"a", "1:2:3", 1500, 1500},
+		}},
+		{"repeated poke on two logins", []pokeCall{
+			{"a", "1:2:3", 0, 0},
+			{"a", "1:2:3", 1000, 1000},
+			{"b", "1:2:3", 1100, 0},
+			{"b", "1:2:3", 1200, 1000},
+			{"b", "1:2:3", 2000, 2000},
+			{"a", "1:2:3", 2100, 2000},
+		}},
+		{"different pokes", []pokeCall{
+			{"a", "1:2:3", 0, 0},
+			{"a", "1:3:2", 100, 100},
+			{"b", "1:3:2", 200, 100},
+		}},
+		{"expired poke", []pokeCall{
+			{"a", "1:2:3", 0, 0},
+			{"b", "1:2:3", 11000, 11000},
+			{"a", "1:2:3", 12000, 11000},
+		}},
+	}
+
+	for _, tt := range tests {
+		qc := &QQConnector{}
+		for i, c := range tt.calls {
+			got := qc.pokeTime(qqid.MakeUserLoginID(c.login), c.key, at(c.now))
+			if !got.Equal(at(c.want)) {
+				t.Errorf("%s: call %d: pokeTime() = %s, want %s", tt.name, i, got.Sub(start), at(c.want).Sub(start))
+			}
+		}
+	}
+}
+
+func TestPokeID(t *testing.T) {
+	first := &QQPokeEvent{ChatID: "1", Sender: 2, Receiver: 3, Timestamp: time.UnixMilli(1700000000100)}
+	second := &QQPokeEvent{ChatID: "1", Sender: 2, Receiver: 3, Timestamp: time.UnixMilli(1700000000600)}
+	same := &QQPokeEvent{ChatID: "1", Sender: 2, Receiver: 3, Timestamp: time.UnixMilli(1700000000100)}
+
+	if first.GetID() == second.GetID() {
+		t.Errorf("pokes in the same second got the same ID %q", first.GetID())
+	}
+	if first.GetID() != same.GetID() {
+		t.Errorf("same poke got different IDs %q and %q", first.GetID(), same.GetID())
+	}
+}
@@ -0,0 +1,434 @@
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/variationselector"
+	"maunium.net/go/mautrix/id"
+)
+
+type face struct {
+	ID    uint16
+	Emoji string
+	Name  string
+}
+
+// Classic, super and animated QQ faces share one ID space.
+// Faces without a reasonable unicode equivalent have an empty emoji and are
+// rendered as inline images if an image URL is configured.
+// When several faces share an emoji, the first one wins in reverse lookups.
+var faces = []face{
+	{0, "😮", "惊讶"},
+	{1, "😕", "撇嘴"},
+	{2, "😍", "色"},
+	{3, "😳", "发呆"},
+	{4, "😎", "得意"},
+	{5, "😢", "流泪"},
+	{6, "😊", "害羞"},
+	{7, "🤐", "闭嘴"},
+	{8, "😴", "睡"},
+	{9, "😭", "大哭"},
+	{10, "😓", "尴尬"},
+	{11, "😡", "发怒"},
+	{12, "😜", "调皮"},
+	{13, "😁", "呲牙"},
+	{14, "🙂", "微笑"},
+	{15, "🙁", "难过"},
+	{16, "", "酷"},
+	{18, "😫", "抓狂"},
+	{19, "🤮", "吐"},
+	{20, "🤭", "偷笑"},
+	{21, "🥰", "可爱"},
+	{22, "🙄", "白眼"},
+	{23, "😤", "傲慢"},
+	{24, "🤤", "饥饿"},
+	{25, "😪", "困"},
+	{26, "😱", "惊恐"},
+	{27, "😅", "流汗"},
+	{28, "😄", "憨笑"},
+	{29, "😌", "悠闲"},
+	{30, "💪", "奋斗"},
+	{31, "🤬", "咒骂"},
+	{32, "🤔", "疑问"},
+	{33, "🤫", "嘘"},
+	{34, "😵", "晕"},
+	{35, "😖", "折磨"},
+	{36, "😩", "衰"},
+	{37, "💀", "骷髅"},
+	{38, "🔨", "敲打"},
+	{39, "👋", "再见"},
+	{41, "🥶", "发抖"},
+	{42, "💑", "爱情"},
+	{46, "🐷", "猪头"},
+	{49, "🤗", "拥抱"},
+	{53, "🎂", "蛋糕"},
+	{54, "⚡", "闪电"},
+	{55, "💣", "炸弹"},
+	{56, "🔪", "刀"},
+	{57, "⚽", "足球"},
+	{59, "💩", "便便"},
+	{60, "☕", "咖啡"},
+	{61, "🍚", "饭"},
+	{63, "🌹", "玫瑰"},
+	{64, "🥀", "凋谢"},
+	{66, "❤️", "爱心"},
+	{67, "💔", "心碎"},
+	{69, "🎁", "礼物"},
+	{74, "☀️", "太阳"},
+	{75, "🌙", "月亮"},
+	{76, "👍", "赞"},
+	{77, "👎", "踩"},
+	{78, "🤝", "握手"},
+	{79, "✌️", "胜利"},
+	{85, "😘", "飞吻"},
+	{86, "😠", "怄火"},
+	{89, "🍉", "西瓜"},
+	{96, "😰", "冷汗"},
+	{97, "", "擦汗"},
+	{98, "", "抠鼻"},
+	{99, "👏", "鼓掌"},
+	{100, "", "糗大了"},
+	{101, "😏", "坏笑"},
+	{102, "", "左哼哼"},
+	{103, "", "右哼哼"},
+	{104, "🥱", "哈欠"},
+	{105, "😒", "鄙视"},
+	{106, "🥺", "委屈"},
+	{107, "", "快哭了"},
+	{108, "😈", "阴险"},
+	{109, "😚", "左亲亲"},
+	{110, "😨", "吓"},
+	{111, "🥹", "可怜"},
+	{112, "", "菜刀"},
+	{113, "🍺", "啤酒"},
+	{114, "🏀", "篮球"},
+	{115, "🏓", "乒乓"},
+	{116, "💋", "示爱"},
+	{117, "🐞", "瓢虫"},
+	{118, "🙏", "抱拳"},
+	{119, "", "勾引"},
+	{120, "👊", "拳头"},
+	{121, "", "差劲"},
+	{122, "🤟", "爱你"},
+	{123, "🙅", "NO"},
+	{124, "👌", "OK"},
+	{144, "🎉", "喝彩"},
+	{146, "💢", "爆筋"},
+	{147, "🍭", "棒棒糖"},
+	{148, "🍼", "喝奶"},
+	{158, "💵", "钞票"},
+	{168, "💊", "药"},
+	{169, "🔫", "手枪"},
+	{171, "🍵", "茶"},
+	{172, "😉", "眨眼睛"},
+	{173, "", "泪奔"},
+	{174, "", "无奈"},
+	{175, "", "卖萌"},
+	{176, "", "小纠结"},
+	{177, "", "喷血"},
+	{178, "", "斜眼笑"},
+	{179, "🐶", "doge"},
+	{180, "🤩", "惊喜"},
+	{181, "", "戳一戳"},
+	{182, "😂", "笑哭"},
+	{183, "💁", "我最美"},
+	{185, "🦙", "羊驼"},
+	{187, "👻", "幽灵"},
+	{201, "👍", "点赞"},
+	{212, "", "托腮"},
+	{214, "😙", "啵啵"},
+	{262, "🤕", "脑阔疼"},
+	{263, "", "辣眼睛"},
+	{264, "🤦", "捂脸"},
+	{266, "😯", "哦哟"},
+	{267, "", "头秃"},
+	{268, "", "问号脸"},
+	{269, "", "暗中观察"},
+	{270, "😑", "emm"},
+	{271, "", "吃瓜"},
+	{272, "", "呵呵哒"},
+	{273, "", "我酸了"},
+	{277, "🐕", "汪汪"},
+	{278, "", "汗"},
+	{281, "", "无眼笑"},
+	{282, "🫡", "敬礼"},
+	{283, "🤣", "狂笑"},
+	{284, "😐", "面无表情"},
+	{285, "🐟", "摸鱼"},
+	{286, "", "魔鬼笑"},
+	{287, "", "哦"},
+	{288, "", "请"},
+	{289, "👀", "睁眼"},
+	{290, "", "敲开心"},
+	{292, "", "让我康康"},
+	{293, "", "摸锦鲤"},
+	{294, "", "期待"},
+	{295, "", "拿到红包"},
+	{297, "🙇", "拜谢"},
+	{298, "", "元宝"},
+	{299, "🐮", "牛啊"},
+	{300, "", "胖三斤"},
+	{302, "", "左拜年"},
+	{303, "", "右拜年"},
+	{305, "", "右亲亲"},
+	{306, "🐂", "牛气冲天"},
+	{307, "🐱", "喵喵"},
+	{311, "", "打call"},
+	{312, "", "变形"},
+	{314, "🧐", "仔细分析"},
+	{317, "", "菜汪"},
+	{318, "", "崇拜"},
+	{319, "🫶", "比心"},
+	{320, "🎊", "庆祝"},
+	{322, "", "拒绝"},
+	{323, "", "嫌弃"},
+	{324, "🍬", "吃糖"},
+	{325, "", "惊吓"},
+	{326, "", "生气"},
+	{332, "", "举牌牌"},
+	{333, "🎆", "烟花"},
+	{334, "🐯", "虎虎生威"},
+	{337, "", "花朵脸"},
+	{338, "", "我想开了"},
+	{339, "", "舔屏"},
+	{341, "", "打招呼"},
+	{342, "", "酸Q"},
+	{343, "", "我方了"},
+	{344, "", "大怨种"},
+	{345, "🧧", "红包多多"},
+	{346, "", "你真棒棒"},
+	{347, "🐰", "大展宏兔"},
+	{349, "", "坚强"},
+	{350, "", "贴贴"},
+	{351, "", "敲敲"},
+	{352, "", "咦"},
+	{353, "", "拜托"},
+	{354, "", "尊嘟假嘟"},
+	{355, "", "耶"},
+	{356, "", "666"},
+	{357, "", "裂开"},
+	{358, "🎲", "骰子"},
+	{359, "", "包剪锤"},
+	{392, "🐲", "龙年快乐"},
+}
+
+// Interactive faces carry a random result and can't be sent as plain faces
+const (
+	faceDice           uint16 = 358
+	faceFingerGuessing uint16 = 359
+)
+
+var (
+	faceByID    = make(map[uint16]*face, len(faces))
+	emojiToFace = make(map[string]uint16, len(faces))
+)
+
+func init() {
+	for i := range faces {
+		f := &faces[i]
+		faceByID[f.ID] = f
+		if f.Emoji == "" || f.ID == faceDice || f.ID == faceFingerGuessing {
+			continue
+		}
+		key := variationselector.Remove(f.Emoji)
+		if _, exists := emojiToFace[key]; !exists {
+			emojiToFace[key] = f.ID
+		}
+	}
+}
+
+// FaceToEmoji returns the unicode emoji of a QQ face.
+func FaceToEmoji(id uint16) (string, bool) {
+	if f, ok := faceByID[id]; ok && f.Emoji != "" {
+		return f.Emoji, true
+	}
+	return "", false
+}
+
+// EmojiToFace returns the QQ face of a unicode emoji.
+func EmojiToFace(emoji string) (uint16, bool) {
+	id, ok := emojiToFace[variationselector.Remove(emoji)]
+	return id, ok
+}
+
+// FaceName returns the Chinese name of a QQ face.
+func FaceName(id uint16) (string, bool) {
+	if f, ok := faceByID[id]; ok {
+		return f.Name, true
+	}
+	return "", false
+}
+
+// faceText is the plain text form of a QQ face: the emoji if there is one,
+// otherwise the name the way QQ itself shows faces in text.
+func faceText(id uint16) string {
+	if emoji, ok := FaceToEmoji(id); ok {
+		return emoji
+	} else if name, ok := FaceName(id); ok {
+		return "/" + name
+	}
+	return fmt.Sprintf("/[Face%d]", id)
+}
+
+// faceResult is the rolled number of a dice face or the hand of a finger guessing face.
+func faceResult(elem *message.FaceElement) string {
+	if elem.ResultID == 0 {
+		return ""
+	}
+	switch elem.FaceID {
+	case faceDice:
+		return strconv.Itoa(int(elem.ResultID))
+	case faceFingerGuessing:
+		return message.FingerGuessingType(elem.ResultID).String()
+	}
+	return ""
+}
+
+// faceElementText is the plain text form of a face element, including its result if it has one.
+func faceElementText(elem *message.FaceElement) string {
+	if result := faceResult(elem); result != "" {
+		return faceText(elem.FaceID) + " " + result
+	}
+	return faceText(elem.FaceID)
+}
+
+// faceHTML renders a face element, using an inline emoticon image for faces without an emoji.
+func (mc *MessageConverter) faceHTML(ctx context.Context, elem *message.FaceElement) string {
+	if result := faceResult(elem); result != "" {
+		return mc.faceImageHTML(ctx, elem.FaceID) + " " + html.EscapeString(result)
+	}
+	return mc.faceImageHTML(ctx, elem.FaceID)
+}
+
+// faceImageHTML renders a QQ face without an emoji as an inline emoticon image.
+// The image is uploaded once per face and the mxc URI is reused afterwards.
+func (mc *MessageConverter) faceImageHTML(ctx context.Context, faceID uint16) string {
+	text := faceText(faceID)
+	if _, ok := FaceToEmoji(faceID); ok || mc.FaceImageURL == "" {
+		return html.EscapeString(text)
+	}
+
+	var uri id.ContentURIString
+	if cached, ok := mc.faceURIs.Load(faceID); ok {
+		uri = cached.(id.ContentURIString)
+	} else {
+		url := strings.ReplaceAll(mc.FaceImageURL, "{id}", strconv.Itoa(int(faceID)))
+		data, err := qqid.GetBytes(url)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Uint16("face_id", faceID).Msg("Failed to download face image")
+			return html.EscapeString(text)
+		}
+		mime := mimetype.Detect(data)
+		uri, _, err = mc.Bridge.Bot.UploadMedia(ctx, "", data, fmt.Sprintf("face%d%s", faceID, mime.Extension()), mime.String())
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Uint16("face_id", faceID).Msg("Failed to upload face image")
+			return html.EscapeString(text)
+		}
+		mc.faceURIs.Store(faceID, uri)
+	}
+
+	return fmt.Sprintf(
+		`<img data-mx-emoticon src="%s" alt="%s" title="%s" height="32">`,
+		uri, html.EscapeString(text), html.EscapeString(text),
+	)
+}
+
+// splitFaces splits text into text and face elements for every emoji with a QQ face.
+// Emojis that are part of a sequence (skin tones, ZWJ) are left as they are.
+func splitFaces(text string) []message.IMessageElement {
+	elems := []message.IMessageElement{}
+	runes := []rune(text)
+
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		id, ok := emojiToFace[string(runes[i])]
+		if !ok {
+			continue
+		}
+		end := i + 1
+		if end < len(runes) && runes[end] == variationSelector16 {
+			end++
+		}
+		if (i > 0 && runes[i-1] == zeroWidthJoiner) || (end < len(runes) && isEmojiModifier(runes[end])) {
+			continue
+		}
+
+		if start < i {
+			elems = append(elems, message.NewText(string(runes[start:i])))
+		}
+		elems = append(elems, message.NewFace(id))
+		start = end
+		i = end - 1
+	}
+	if start < len(runes) {
+		elems = append(elems, message.NewText(string(runes[start:])))
+	}
+
+	return elems
+}
+
+const (
+	variationSelector16 = '\uFE0F'
+	zeroWidthJoiner     = '\u200D'
+)
+
+func isEmojiModifier(r rune) bool {
+	return r == zeroWidthJoiner || (r >= 0x1F3FB && r <= 0x1F3FF)
+}
+
+// ReactionCodeFromEmoji converts a reaction key into a QQ group reaction code.
+// Emojis with a QQ face use the face ID, other single code point emojis
+// fall back to the decimal code point, which QQ accepts as an emoji reaction.
+// Faces without an emoji are accepted in their shortcode form.
+func ReactionCodeFromEmoji(emoji string) (string, error) {
+	if id, ok := EmojiToFace(emoji); ok {
+		return fmt.Sprint(id), nil
+	}
+
+	var faceID uint16
+	if n, err := fmt.Sscanf(emoji, faceShortcodeFormat, &faceID); err == nil && n == 1 && emoji == faceShortcode(faceID) {
+		return fmt.Sprint(faceID), nil
+	}
+
+	emoji = variationselector.Remove(emoji)
+	// Shorter codes would be taken for face IDs
+	if r, size := utf8.DecodeRuneInString(emoji); r != utf8.RuneError && size == len(emoji) && r > 999 {
+		return fmt.Sprint(r), nil
+	}
+
+	return "", fmt.Errorf("emoji %q has no QQ equivalent", emoji)
+}
+
+// EmojiFromReactionCode converts a QQ group reaction code into a reaction key.
+// isEmoji is the reaction type of the QQ event, telling code points from face IDs.
+func EmojiFromReactionCode(code string, isEmoji bool) string {
+	num, err := strconv.ParseUint(code, 10, 32)
+	if err != nil {
+		return code
+	}
+
+	if isEmoji {
+		return variationselector.Add(string(rune(num)))
+	}
+	if emoji, ok := FaceToEmoji(uint16(num)); ok {
+		return emoji
+	}
+	return faceShortcode(uint16(num))
+}
+
+const faceShortcodeFormat = ":qq_face_%d:"
+
+// faceShortcode is the reaction key of faces without an emoji.
+func faceShortcode(id uint16) string {
+	return fmt.Sprintf(faceShortcodeFormat, id)
+}
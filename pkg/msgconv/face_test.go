@@ -0,0 +1,129 @@
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+)
+
+func TestSplitFaces(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"hello", "hello"},
+		{"😮", "[face 0]"},
+		{"hi 😮!", "hi |[face 0]|!"},
+		{"😮😮", "[face 0]|[face 0]"},
+		{"love ❤️ you", "love |[face 66]| you"},
+		{"love ❤ you", "love |[face 66]| you"},
+		// Skin tones and ZWJ sequences are not faces
+		{"👍🏻", "👍🏻"},
+		{"👨‍👩‍👧", "👨‍👩‍👧"},
+		{"🦀", "🦀"},
+	}
+
+	for _, tt := range tests {
+		if got := describeElements(splitFaces(tt.text)); got != tt.want {
+			t.Errorf("splitFaces(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func describeElements(elems []message.IMessageElement) string {
+	parts := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		switch e := elem.(type) {
+		case *message.TextElement:
+			parts = append(parts, e.Content)
+		case *message.FaceElement:
+			parts = append(parts, fmt.Sprintf("[face %d]", e.FaceID))
+		case *message.AtElement:
+			parts = append(parts, fmt.Sprintf("[at %d]", e.TargetUin))
+		default:
+			parts = append(parts, fmt.Sprintf("[%T]", elem))
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+func TestReactionCodeFromEmoji(t *testing.T) {
+	valid := map[string]string{
+		"😮":            "0",
+		"👍":            "76",
+		"❤️":           "66",
+		"❤":            "66",
+		"🦀":            "129408",
+		":qq_face_16:": "16",
+	}
+	for emoji, want := range valid {
+		if code, err := ReactionCodeFromEmoji(emoji); err != nil || code != want {
+			t.Errorf("ReactionCodeFromEmoji(%q) = %q, %v, want %q", emoji, code, err, want)
+		}
+	}
+
+	for _, emoji := range []string{"", "a", "é", "🦀🦀", ":qq_face_x:", ":qq_face_16:x"} {
+		if code, err := ReactionCodeFromEmoji(emoji); err == nil {
+			t.Errorf("ReactionCodeFromEmoji(%q) = %q, want error", emoji, code)
+		}
+	}
+}
+
+func TestEmojiFromReactionCode(t *testing.T) {
+	tests := []struct {
+		code    string
+		isEmoji bool
+		want    string
+	}{
+		{"0", false, "😮"},
+		{"76", false, "👍"},
+		{"16", false, ":qq_face_16:"},
+		{"1024", false, ":qq_face_1024:"},
+		{"129408", true, "🦀"},
+		{"128512", true, "😀"},
+		{"abc", false, "abc"},
+	}
+
+	for _, tt := range tests {
+		if got := EmojiFromReactionCode(tt.code, tt.isEmoji); got != tt.want {
+			t.Errorf("EmojiFromReactionCode(%q, %v) = %q, want %q", tt.code, tt.isEmoji, got, tt.want)
+		}
+	}
+
+	// Reactions from QQ can be added from Matrix again
+	for _, code := range []string{"0", "16", "76"} {
+		if back, err := ReactionCodeFromEmoji(EmojiFromReactionCode(code, false)); err != nil || back != code {
+			t.Errorf("face %s doesn't round trip, got %q, %v", code, back, err)
+		}
+	}
+}
+
+func TestFaceElementText(t *testing.T) {
+	mc := &MessageConverter{}
+
+	tests := []struct {
+		elem *message.FaceElement
+		text string
+		html string
+	}{
+		{message.NewFace(0), "😮", "😮"},
+		{message.NewFace(16), "/酷", "/酷"},
+		{message.NewDice(4), "🎲 4", "🎲 4"},
+		{&message.FaceElement{FaceID: faceDice}, "🎲", "🎲"},
+		{message.NewFingerGuessing(message.FingerGuessingRock), "/包剪锤 石头", "/包剪锤 石头"},
+		{&message.FaceElement{FaceID: 16, ResultID: 3}, "/酷", "/酷"},
+	}
+
+	for _, tt := range tests {
+		if got := faceElementText(tt.elem); got != tt.text {
+			t.Errorf("faceElementText(%d, %d) = %q, want %q", tt.elem.FaceID, tt.elem.ResultID, got, tt.text)
+		}
+		if got := mc.faceHTML(context.Background(), tt.elem); got != tt.html {
+			t.Errorf("faceHTML(%d, %d) = %q, want %q", tt.elem.FaceID, tt.elem.ResultID, got, tt.html)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"github.com/antchfx/xmlquery"
+	"github.com/rs/zerolog"
+	"github.com/tidwall/gjson"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+)
+
+const forwardTimeFormat = "2006-01-02 15:04:05"
+
+// convertForwardMessage renders a merged forward as quotes. Images are shown inline,
+// other media and images in encrypted rooms follow as their own parts, as HTML can't link to them.
+func (mc *MessageConverter) convertForwardMessage(ctx context.Context, forward *message.ForwardMessage) []*bridgev2.ConvertedMessagePart {
+	var attachments []*bridgev2.ConvertedMessagePart
+	body, formatted, err := mc.renderForward(ctx, forward, 1, &attachments)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("res_id", forward.ResID).Msg("Failed to fetch forwarded messages")
+		return []*bridgev2.ConvertedMessagePart{{
+			Type: event.EventMessage,
+			Content: &event.MessageEventContent{
+				MsgType:  event.MsgNotice,
+				Body:     fmt.Sprintf("Failed to fetch forwarded messages: %v", err),
+				Mentions: &event.Mentions{},
+			},
+		}}
+	}
+
+	parts := []*bridgev2.ConvertedMessagePart{{
+		Type: event.EventMessage,
+		Content: &event.MessageEventContent{
+			MsgType:       event.MsgText,
+			Format:        event.FormatHTML,
+			Body:          "Forwarded messages:\n" + body,
+			FormattedBody: "<strong>Forwarded messages</strong>" + formatted,
+			Mentions:      &event.Mentions{},
+		},
+	}}
+	for _, part := range attachments {
+		part.Content.Mentions = &event.Mentions{}
+		parts = append(parts, part)
+	}
+
+	return parts
+}
+
+// forwardResID returns the resource ID of a merged forward sent as a LightApp or XML card.
+func forwardResID(elem message.IMessageElement) string {
+	switch e := elem.(type) {
+	case *message.LightAppElement:
+		if gjson.Get(e.Content, "app").String() == "com.tencent.multimsg" {
+			return gjson.Get(e.Content, "meta.detail.resid").String()
+		}
+	case *message.XMLElement:
+		if doc, err := xmlquery.Parse(strings.NewReader(e.Content)); err == nil {
+			if action := xmlquery.FindOne(doc, "//msg[@action='viewMultiMsg']"); action != nil {
+				return action.SelectAttr("m_resid")
+			}
+		}
+	}
+	return ""
+}
+
+// renderForward renders every node of a merged forward as a quote.
+// Nested forwards are followed until ForwardDepth is reached.
+func (mc *MessageConverter) renderForward(ctx context.Context, forward *message.ForwardMessage, depth int, attachments *[]*bridgev2.ConvertedMessagePart) (string, string, error) {
+	nodes := forward.Nodes
+	if len(nodes) == 0 {
+		fetched, err := getClient(ctx).FetchForwardMsg(forward.ResID)
+		if err != nil {
+			return "", "", err
+		}
+		nodes = fetched.Nodes
+	}
+
+	var body, formatted strings.Builder
+	for _, node := range nodes {
+		sender := node.SenderName
+		if sender == "" {
+			sender = fmt.Sprint(node.SenderID)
+		}
+		ts := time.Unix(int64(node.Time), 0).Format(forwardTimeFormat)
+
+		text, content := mc.renderForwardElements(ctx, node.Message, depth, attachments)
+
+		fmt.Fprintf(&body, "> %s (%s):\n> %s\n", sender, ts, strings.ReplaceAll(text, "\n", "\n> "))
+		fmt.Fprintf(
+			&formatted,
+			"<blockquote><strong>%s</strong> <small>%s</small><br>%s</blockquote>",
+			html.EscapeString(sender), ts, content,
+		)
+	}
+
+	return body.String(), formatted.String(), nil
+}
+
+func (mc *MessageConverter) renderForwardElements(ctx context.Context, elems []message.IMessageElement, depth int, attachments *[]*bridgev2.ConvertedMessagePart) (string, string) {
+	var body, formatted strings.Builder
+
+	for _, elem := range elems {
+		// Nested forwards may also arrive as cards
+		if resID := forwardResID(elem); resID != "" {
+			elem = message.NewForwardWithResID(resID)
+		}
+
+		switch e := elem.(type) {
+		case *message.ReplyElement:
+		case *message.TextElement:
+			body.WriteString(e.Content)
+			formatted.WriteString(linkify(e.Content))
+		case *message.AtElement:
+			mention := e.Display
+			if mention == "" {
+				mention = fmt.Sprintf("@%d", e.TargetUin)
+			}
+			body.WriteString(mention)
+			formatted.WriteString(html.EscapeString(mention))
+		case *message.FaceElement:
+			body.WriteString(faceElementText(e))
+			formatted.WriteString(mc.faceHTML(ctx, e))
+		case *message.ImageElement, *message.VoiceElement, *message.ShortVideoElement, *message.FileElement:
+			placeholder := toContent([]message.IMessageElement{elem})
+			body.WriteString(placeholder)
+
+			part, err := mc.reploadAttachment(ctx, elem)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Msg("Failed to reupload forwarded attachment")
+				formatted.WriteString(html.EscapeString(placeholder))
+			} else if part.Content.MsgType == event.MsgImage && part.Content.URL != "" {
+				fmt.Fprintf(&formatted, `<img src="%s" alt="%s">`, part.Content.URL, html.EscapeString(part.Content.FileName))
+			} else {
+				formatted.WriteString(html.EscapeString(placeholder))
+				*attachments = append(*attachments, part)
+			}
+		case *message.ForwardMessage:
+			if depth >= mc.ForwardDepth {
+				body.WriteString("[Forward]")
+				formatted.WriteString("[Forward]")
+				continue
+			}
+			text, content, err := mc.renderForward(ctx, e, depth+1, attachments)
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Str("res_id", e.ResID).Msg("Failed to fetch nested forwarded messages")
+				body.WriteString("[Forward]")
+				formatted.WriteString("[Forward]")
+				continue
+			}
+			body.WriteString("\n" + strings.TrimSuffix(text, "\n"))
+			formatted.WriteString(content)
+		default:
+			text := toContent([]message.IMessageElement{elem})
+			body.WriteString(text)
+			formatted.WriteString(html.EscapeString(text))
+		}
+	}
+
+	return body.String(), formatted.String()
+}
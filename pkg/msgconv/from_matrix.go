@@ -65,7 +65,7 @@ func (mc *MessageConverter) constructTextMessage(ctx context.Context, content *e
 	}
 
 	if len(mentions) == 0 {
-		return []message.IMessageElement{message.NewText(text)}
+		return splitFaces(text)
 	}
 
 	keywords := make([]string, len(mentions))
@@ -99,7 +99,7 @@ func (mc *MessageConverter) constructTextMessage(ctx context.Context, content *e
 				elems = append(elems, message.NewAt(uint32(uin)))
 			}
 		} else {
-			elems = append(elems, message.NewText(s))
+			elems = append(elems, splitFaces(s)...)
 		}
 	}
 
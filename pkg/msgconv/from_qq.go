@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"html"
 	"math"
+	"strconv"
 	"strings"
 
 	"github.com/duo/matrix-qq/pkg/qqid"
@@ -42,10 +43,11 @@ func (mc *MessageConverter) ToMatrix(
 	ctx = context.WithValue(ctx, contextKeyPortal, portal)
 
 	var part *bridgev2.ConvertedMessagePart
+	var parts []*bridgev2.ConvertedMessagePart
 
 	switch msg.Type {
 	case qqid.MsgImage:
-		part = mc.convertImageMessage(ctx, msg)
+		parts = mc.convertMixedMessage(ctx, msg)
 	case qqid.MsgAudio:
 		part = mc.convertMediaMessage(ctx, msg)[0]
 	case qqid.MsgVideo:
@@ -53,21 +55,65 @@ func (mc *MessageConverter) ToMatrix(
 	case qqid.MsgFile:
 		part = mc.convertMediaMessage(ctx, msg)[0]
 	case qqid.MsgApp:
-		part = mc.convertAppMessage(ctx, msg)
+		if resID := forwardResID(msg.Elements[0]); resID != "" && mc.ForwardDepth > 0 {
+			parts = mc.convertForwardMessage(ctx, message.NewForwardWithResID(resID))
+		} else {
+			part = mc.convertAppMessage(ctx, msg)
+		}
+	case qqid.MsgForward:
+		part = mc.convertTextMessage(ctx, msg)
+		for _, elem := range msg.Elements {
+			if v, ok := elem.(*message.ForwardMessage); ok && mc.ForwardDepth > 0 {
+				parts = mc.convertForwardMessage(ctx, v)
+				break
+			}
+		}
 	case qqid.MsgRevoke:
 		part = mc.convertRevokeMessage(ctx, msg)
 	case qqid.MsgSticker:
+		part = mc.convertStickerMessage(ctx, msg)
 	case qqid.MsgLocation:
-	default:
-		part = mc.convertTextMessage(ctx, msg)
+		part = mc.convertLocationMessage(ctx, msg)
 	}
+	if len(parts) == 0 {
+		if part == nil {
+			part = mc.convertTextMessage(ctx, msg)
+		}
 
-	// Mentions
-	part.Content.Mentions = &event.Mentions{}
-	mc.addMentions(ctx, msg.Elements, part.Content)
+		if part.Content.Mentions == nil {
+			part.Content.Mentions = &event.Mentions{}
+		}
+
+		parts = []*bridgev2.ConvertedMessagePart{part}
+	}
+
+	// Keep a plain text copy so recall notices can quote it later
+	if msg.Type != qqid.MsgRevoke {
+		text := parts[0].Content.Body
+		if len(parts) > 1 || parts[0].Content.MsgType.IsMedia() {
+			text = toContent(msg.Elements)
+		}
+		for _, part := range parts {
+			meta := &qqid.MessageMetadata{
+				Text:      text,
+				MediaType: qqid.MediaMsgType(part.Type, part.Content),
+			}
+			if len(parts) > 1 && meta.MediaType == "" {
+				meta.PartText = part.Content.Body
+			}
+			part.DBMetadata = meta
+		}
+	}
+
+	// The first part keeps the empty part ID, so it can be addressed like single part messages
+	for i, part := range parts {
+		if i > 0 {
+			part.ID = networkid.PartID(strconv.Itoa(i))
+		}
+	}
 
 	cm := &bridgev2.ConvertedMessage{
-		Parts: []*bridgev2.ConvertedMessagePart{part},
+		Parts: parts,
 	}
 
 	// ReplyTo
@@ -84,38 +130,10 @@ func (mc *MessageConverter) ToMatrix(
 	return cm
 }
 
-func (mc *MessageConverter) convertTextMessage(_ context.Context, msg *qqid.Message) *bridgev2.ConvertedMessagePart {
+func (mc *MessageConverter) convertTextMessage(ctx context.Context, msg *qqid.Message) *bridgev2.ConvertedMessagePart {
 	return &bridgev2.ConvertedMessagePart{
-		Type: event.EventMessage,
-		Content: &event.MessageEventContent{
-			MsgType: event.MsgText,
-			Body:    toContent(msg.Elements),
-		},
-	}
-}
-
-func (mc *MessageConverter) convertImageMessage(ctx context.Context, msg *qqid.Message) *bridgev2.ConvertedMessagePart {
-	parts := mc.convertMediaMessage(ctx, msg)
-	if len(parts) == 1 {
-		return parts[0]
-	}
-
-	var imagesMarkdown strings.Builder
-	for _, part := range parts {
-		fmt.Fprintf(&imagesMarkdown, "![%s](%s)\n", part.Content.FileName, part.Content.URL)
-	}
-
-	rendered := format.RenderMarkdown(imagesMarkdown.String(), true, false)
-	content := toContent(msg.Elements)
-
-	return &bridgev2.ConvertedMessagePart{
-		Type: event.EventMessage,
-		Content: &event.MessageEventContent{
-			MsgType:       event.MsgText,
-			Format:        event.FormatHTML,
-			Body:          content,
-			FormattedBody: fmt.Sprintf("%s\n%s", rendered.FormattedBody, content),
-		},
+		Type:    event.EventMessage,
+		Content: mc.renderText(ctx, msg.Elements),
 	}
 }
 
@@ -137,7 +155,11 @@ func (mc *MessageConverter) convertMediaMessage(ctx context.Context, msg *qqid.M
 	return parts
 }
 
-func (mc *MessageConverter) convertAppMessage(_ context.Context, msg *qqid.Message) *bridgev2.ConvertedMessagePart {
+func (mc *MessageConverter) convertAppMessage(ctx context.Context, msg *qqid.Message) *bridgev2.ConvertedMessagePart {
+	if loc := qqid.ParseLocation(msg.Elements[0]); loc != nil {
+		return mc.makeLocationPart(ctx, loc)
+	}
+
 	// XML
 	if v, ok := msg.Elements[0].(*message.XMLElement); ok {
 		body := v.Content
@@ -175,22 +197,12 @@ func (mc *MessageConverter) convertAppMessage(_ context.Context, msg *qqid.Messa
 	var desc string
 	var url string
 
-	view := gjson.Get(content, "view").String()
-	if view == "LocationShare" {
-		name := gjson.Get(content, "meta.*.name").String()
-		address := gjson.Get(content, "meta.*.address").String()
-		latitude := gjson.Get(content, "meta.*.lat").Float()
-		longitude := gjson.Get(content, "meta.*.lng").Float()
-
-		return mc.convertLocationMessage(name, address, latitude, longitude)
-	} else {
-		if url = gjson.Get(content, "meta.*.qqdocurl").String(); len(url) > 0 {
-			desc = gjson.Get(content, "meta.*.desc").String()
-			title = gjson.Get(content, "prompt").String()
-		} else if url = gjson.Get(content, "meta.*.jumpUrl").String(); len(url) > 0 {
-			desc = gjson.Get(content, "meta.*.desc").String()
-			title = gjson.Get(content, "prompt").String()
-		}
+	if url = gjson.Get(content, "meta.*.qqdocurl").String(); len(url) > 0 {
+		desc = gjson.Get(content, "meta.*.desc").String()
+		title = gjson.Get(content, "prompt").String()
+	} else if url = gjson.Get(content, "meta.*.jumpUrl").String(); len(url) > 0 {
+		desc = gjson.Get(content, "meta.*.desc").String()
+		title = gjson.Get(content, "prompt").String()
 	}
 
 	body := fmt.Sprintf("%s\n\n%s\n\n%s", title, desc, url)
@@ -211,8 +223,20 @@ func (mc *MessageConverter) convertAppMessage(_ context.Context, msg *qqid.Messa
 	}
 }
 
-func (mc *MessageConverter) convertLocationMessage(name, address string, lat, lng float64) *bridgev2.ConvertedMessagePart {
-	url := fmt.Sprintf("https://maps.google.com/?q=%.5f,%.5f", lat, lng)
+func (mc *MessageConverter) convertLocationMessage(ctx context.Context, msg *qqid.Message) *bridgev2.ConvertedMessagePart {
+	for _, elem := range msg.Elements {
+		if loc := qqid.ParseLocation(elem); loc != nil {
+			return mc.makeLocationPart(ctx, loc)
+		}
+	}
+
+	return mc.convertTextMessage(ctx, msg)
+}
+
+func (mc *MessageConverter) makeLocationPart(ctx context.Context, loc *qqid.Location) *bridgev2.ConvertedMessagePart {
+	name, address, lat, lng := loc.Name, loc.Address, loc.Latitude, loc.Longitude
+
+	url := mc.locationURL(lat, lng)
 	if len(name) == 0 {
 		latChar := 'N'
 		if lat < 0 {
@@ -229,24 +253,62 @@ func (mc *MessageConverter) convertLocationMessage(name, address string, lat, ln
 		MsgType:       event.MsgLocation,
 		Body:          fmt.Sprintf("Location: %s\n%s\n%s", name, address, url),
 		Format:        event.FormatHTML,
-		FormattedBody: fmt.Sprintf("Location: <a href='%s'>%s</a><br>%s", url, name, address),
+		FormattedBody: fmt.Sprintf("Location: <a href='%s'>%s</a><br>%s", html.EscapeString(url), html.EscapeString(name), html.EscapeString(address)),
 		GeoURI:        fmt.Sprintf("geo:%.5f,%.5f", lat, lng),
 	}
 
+	if mc.MapTileURL != "" {
+		if err := mc.addLocationThumbnail(ctx, content, lat, lng); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to render location thumbnail")
+		}
+	}
+
 	return &bridgev2.ConvertedMessagePart{
 		Type:    event.EventMessage,
 		Content: content,
 	}
 }
 
-func (mc *MessageConverter) convertRevokeMessage(_ context.Context, _ *qqid.Message) *bridgev2.ConvertedMessagePart {
+func (mc *MessageConverter) convertRevokeMessage(ctx context.Context, msg *qqid.Message) *bridgev2.ConvertedMessagePart {
+	portal := getPortal(ctx)
+
+	operator := msg.SenderID
+	if _, name, err := mc.getBasicUserInfo(ctx, qqid.MakeUserID(msg.SenderID)); err == nil && name != "" {
+		operator = name
+	}
+
+	body := fmt.Sprintf("%s recalled a message", operator)
+	var quote string
+
+	target, err := mc.Bridge.DB.Message.GetFirstPartByID(ctx, portal.Receiver, qqid.MakeMessageID(msg.ChatID, msg.ID))
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("message_id", msg.ID).Msg("Failed to get recalled message")
+	} else if target != nil {
+		if target.SenderID != qqid.MakeUserID(msg.SenderID) {
+			author := string(target.SenderID)
+			if _, name, err := mc.getBasicUserInfo(ctx, target.SenderID); err == nil && name != "" {
+				author = name
+			}
+			body = fmt.Sprintf("%s recalled a message from %s", operator, author)
+		}
+		if meta, ok := target.Metadata.(*qqid.MessageMetadata); ok {
+			quote = meta.Text
+		}
+	}
+
+	formatted := fmt.Sprintf("<del>%s</del>", html.EscapeString(body))
+	if quote != "" {
+		formatted += fmt.Sprintf("<blockquote>%s</blockquote>", strings.ReplaceAll(html.EscapeString(quote), "\n", "<br>"))
+		body += ":\n> " + strings.ReplaceAll(quote, "\n", "\n> ")
+	}
+
 	return &bridgev2.ConvertedMessagePart{
 		Type: event.EventMessage,
 		Content: &event.MessageEventContent{
 			MsgType:       event.MsgNotice,
 			Format:        event.FormatHTML,
-			Body:          "revoke message",
-			FormattedBody: "<del>revoke message</del>",
+			Body:          body,
+			FormattedBody: formatted,
 		},
 	}
 }
@@ -316,54 +378,6 @@ func (mc *MessageConverter) makeMediaFailure(ctx context.Context, err error) *br
 	}
 }
 
-func (mc *MessageConverter) addMentions(ctx context.Context, elems []message.IMessageElement, into *event.MessageEventContent) {
-	if len(elems) == 0 {
-		return
-	}
-
-	mentionedID := make([]string, 0)
-	for _, elem := range elems {
-		if v, ok := elem.(*message.AtElement); ok {
-			if v.TargetUin == 0 {
-				mentionedID = append(mentionedID, "room")
-			} else {
-				mentionedID = append(mentionedID, fmt.Sprint(v.TargetUin))
-			}
-		}
-	}
-
-	// Remove first reply mention id (group chat)
-	if _, ok := elems[0].(*message.ReplyElement); ok {
-		if len(mentionedID) > 0 {
-			mentionedID = mentionedID[1:]
-		}
-	}
-
-	if len(mentionedID) == 0 {
-		return
-	}
-
-	into.EnsureHasHTML()
-
-	for _, id := range mentionedID {
-		if id == "room" {
-			into.Mentions.Room = true
-			continue
-		}
-
-		// TODO: get group nickname
-		mxid, displayname, err := mc.getBasicUserInfo(ctx, qqid.MakeUserID(id))
-		if err != nil {
-			zerolog.Ctx(ctx).Err(err).Str("id", id).Msg("Failed to get user info")
-			continue
-		}
-		into.Mentions.UserIDs = append(into.Mentions.UserIDs, mxid)
-		mentionText := "@" + id
-		into.Body = strings.ReplaceAll(into.Body, mentionText, displayname)
-		into.FormattedBody = strings.ReplaceAll(into.FormattedBody, mentionText, fmt.Sprintf(`<a href="%s">%s</a>`, mxid.URI().MatrixToURL(), html.EscapeString(displayname)))
-	}
-}
-
 func (mc *MessageConverter) getBasicUserInfo(ctx context.Context, user networkid.UserID) (id.UserID, string, error) {
 	ghost, err := mc.Bridge.GetGhostByID(ctx, user)
 	if err != nil {
@@ -379,8 +393,8 @@ func (mc *MessageConverter) getBasicUserInfo(ctx context.Context, user networkid
 func toContent(elems []message.IMessageElement) string {
 	var content strings.Builder
 
-	mentionIndex := 0
-	for _, elem := range elems {
+	skip := qqid.ReplyMentionIndex(elems)
+	for i, elem := range elems {
 		switch e := elem.(type) {
 		case *message.ReplyElement:
 		case *message.TextElement:
@@ -390,9 +404,8 @@ func toContent(elems []message.IMessageElement) string {
 		case *message.XMLElement:
 			fmt.Fprint(&content, e.Content)
 		case *message.AtElement:
-			mentionIndex++
-			// Skip first reply mention
-			if _, ok := elems[0].(*message.ReplyElement); ok && mentionIndex == 1 {
+			// Skip reply mention
+			if i == skip {
 				continue
 			}
 			if e.TargetUin == 0 {
@@ -403,7 +416,7 @@ func toContent(elems []message.IMessageElement) string {
 		case *message.ForwardMessage:
 			fmt.Fprintf(&content, "[Forward: %s]", e.ResID)
 		case *message.FaceElement:
-			fmt.Fprintf(&content, "/[Face%d]", e.FaceID)
+			fmt.Fprint(&content, faceElementText(e))
 		case *message.ImageElement:
 			fmt.Fprintf(&content, "[Image]")
 		case *message.VoiceElement:
@@ -418,11 +431,9 @@ func toContent(elems []message.IMessageElement) string {
 	return content.String()
 }
 
-/*
 func getClient(ctx context.Context) *client.QQClient {
 	return ctx.Value(contextKeyClient).(*client.QQClient)
 }
-*/
 
 func getIntent(ctx context.Context) bridgev2.MatrixAPI {
 	return ctx.Value(contextKeyIntent).(bridgev2.MatrixAPI)
@@ -0,0 +1,199 @@
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+)
+
+const (
+	defaultLocationURL = "https://maps.google.com/?q={lat},{lng}"
+	defaultMapZoom     = 15
+
+	tileSize        = 256
+	thumbnailWidth  = 512
+	thumbnailHeight = 320
+	markerRadius    = 8
+
+	// Tile servers ask clients to identify themselves
+	tileUserAgent = "matrix-qq bridge (+https://github.com/duo/matrix-qq)"
+)
+
+// Tiles are fetched with their own client, so a slow tile server can't hold up message handling for long
+var tileClient = &http.Client{Timeout: 10 * time.Second}
+
+func newTileCache() *lru.Cache[string, image.Image] {
+	cache, _ := lru.New[string, image.Image](256)
+	return cache
+}
+
+func (mc *MessageConverter) locationURL(lat, lng float64) string {
+	template := mc.LocationURL
+	if template == "" {
+		template = defaultLocationURL
+	}
+
+	return strings.NewReplacer(
+		"{lat}", fmt.Sprintf("%.5f", lat),
+		"{lng}", fmt.Sprintf("%.5f", lng),
+	).Replace(template)
+}
+
+// addLocationThumbnail renders a static map around the location from map tiles
+// and attaches it to the content as the thumbnail.
+func (mc *MessageConverter) addLocationThumbnail(ctx context.Context, content *event.MessageEventContent, lat, lng float64) error {
+	data, err := mc.renderStaticMap(ctx, lat, lng)
+	if err != nil {
+		return err
+	}
+
+	uri, file, err := getIntent(ctx).UploadMedia(ctx, getPortal(ctx).MXID, data, "location.png", "image/png")
+	if err != nil {
+		return fmt.Errorf("failed to upload thumbnail: %w", err)
+	}
+
+	if content.Info == nil {
+		content.Info = &event.FileInfo{}
+	}
+	content.Info.ThumbnailURL = uri
+	content.Info.ThumbnailFile = file
+	content.Info.ThumbnailInfo = &event.FileInfo{
+		MimeType: "image/png",
+		Width:    thumbnailWidth,
+		Height:   thumbnailHeight,
+		Size:     len(data),
+	}
+
+	return nil
+}
+
+func (mc *MessageConverter) renderStaticMap(ctx context.Context, lat, lng float64) ([]byte, error) {
+	zoom := mc.MapZoom
+	if zoom <= 0 {
+		zoom = defaultMapZoom
+	}
+	n := 1 << zoom
+
+	// Global pixel coordinates of the location in web mercator
+	latRad := lat * math.Pi / 180
+	centerX := (lng + 180) / 360 * float64(n) * tileSize
+	centerY := (1 - math.Log(math.Tan(latRad)+1/math.Cos(latRad))/math.Pi) / 2 * float64(n) * tileSize
+
+	left := int(math.Floor(centerX)) - thumbnailWidth/2
+	top := int(math.Floor(centerY)) - thumbnailHeight/2
+
+	img := image.NewRGBA(image.Rect(0, 0, thumbnailWidth, thumbnailHeight))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0xE0, 0xE0, 0xE0, 0xFF}), image.Point{}, draw.Src)
+
+	// The tiles are fetched in parallel and drawn once all of them are done
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	drawn := 0
+	for ty := floorDiv(top, tileSize); ty <= floorDiv(top+thumbnailHeight-1, tileSize); ty++ {
+		if ty < 0 || ty >= n {
+			continue
+		}
+		for tx := floorDiv(left, tileSize); tx <= floorDiv(left+thumbnailWidth-1, tileSize); tx++ {
+			wg.Add(1)
+			go func(tx, ty int) {
+				defer wg.Done()
+
+				tile, err := mc.fetchTile(ctx, zoom, ((tx%n)+n)%n, ty)
+				if err != nil {
+					zerolog.Ctx(ctx).Debug().Err(err).Int("x", tx).Int("y", ty).Msg("Failed to fetch map tile")
+					return
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				at := image.Pt(tx*tileSize-left, ty*tileSize-top)
+				draw.Draw(img, image.Rectangle{Min: at, Max: at.Add(image.Pt(tileSize, tileSize))}, tile, tile.Bounds().Min, draw.Src)
+				drawn++
+			}(tx, ty)
+		}
+	}
+	wg.Wait()
+	if drawn == 0 {
+		return nil, fmt.Errorf("failed to fetch any map tiles")
+	}
+
+	drawMarker(img, thumbnailWidth/2, thumbnailHeight/2)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fetchTile downloads a map tile, tiles are cached as nearby locations share them.
+func (mc *MessageConverter) fetchTile(ctx context.Context, zoom, x, y int) (image.Image, error) {
+	url := strings.NewReplacer(
+		"{z}", strconv.Itoa(zoom),
+		"{x}", strconv.Itoa(x),
+		"{y}", strconv.Itoa(y),
+	).Replace(mc.MapTileURL)
+	if cached, ok := mc.tileCache.Get(url); ok {
+		return cached, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", tileUserAgent)
+
+	resp, err := tileClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	tile, _, err := image.Decode(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	mc.tileCache.Add(url, tile)
+
+	return tile, nil
+}
+
+func drawMarker(img *image.RGBA, cx, cy int) {
+	outline := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	fill := color.RGBA{0xE5, 0x39, 0x35, 0xFF}
+	for y := -markerRadius; y <= markerRadius; y++ {
+		for x := -markerRadius; x <= markerRadius; x++ {
+			d := x*x + y*y
+			if d <= (markerRadius-2)*(markerRadius-2) {
+				img.Set(cx+x, cy+y, fill)
+			} else if d <= markerRadius*markerRadius {
+				img.Set(cx+x, cy+y, outline)
+			}
+		}
+	}
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 {
+		return (a - b + 1) / b
+	}
+	return a / b
+}
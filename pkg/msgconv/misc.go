@@ -94,3 +94,34 @@ func ogg2silk(rawData []byte) ([]byte, error) {
 
 	return silkData, nil
 }
+
+func animated2webp(rawData []byte, ext string) ([]byte, error) {
+	inputFile, err := os.CreateTemp("", "sticker-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(inputFile.Name())
+	inputFile.Close()
+	if err := os.WriteFile(inputFile.Name(), rawData, 0o644); err != nil {
+		return nil, err
+	}
+
+	webpFile, err := os.CreateTemp("", "webp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(webpFile.Name())
+	webpFile.Close()
+	{
+		cmd := exec.Command(
+			"ffmpeg", "-y", "-i", inputFile.Name(), "-c:v", "libwebp", "-lossless", "0", "-q:v", "80", "-loop", "0", "-f", "webp", webpFile.Name())
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		if err := cmd.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	return os.ReadFile(webpFile.Name())
+}
@@ -0,0 +1,155 @@
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+)
+
+// How messages mixing text and images are bridged
+const (
+	// Every text run and image becomes its own Matrix event, in order
+	MixedModeParts = "parts"
+	// A single HTML message with the images inline, falls back to parts in encrypted rooms
+	MixedModeInline = "inline"
+)
+
+// segment is either a run of text elements or an uploaded image.
+type segment struct {
+	elems []message.IMessageElement
+	media *bridgev2.ConvertedMessagePart
+}
+
+// convertMixedMessage converts a message containing images while keeping
+// the order of text and images. Mentions are already set on the returned parts.
+func (mc *MessageConverter) convertMixedMessage(ctx context.Context, msg *qqid.Message) []*bridgev2.ConvertedMessagePart {
+	segments := mc.splitSegments(ctx, msg.Elements)
+
+	// A lone image is sent as is
+	if len(segments) == 1 && segments[0].media != nil {
+		segments[0].media.Content.Mentions = &event.Mentions{}
+		return []*bridgev2.ConvertedMessagePart{segments[0].media}
+	}
+
+	if mc.MixedMessages == MixedModeInline {
+		if part := mc.renderInline(ctx, segments); part != nil {
+			return []*bridgev2.ConvertedMessagePart{part}
+		}
+	}
+
+	parts := make([]*bridgev2.ConvertedMessagePart, 0, len(segments))
+	for _, seg := range segments {
+		if seg.media != nil {
+			seg.media.Content.Mentions = &event.Mentions{}
+			parts = append(parts, seg.media)
+			continue
+		}
+
+		part := mc.convertTextMessage(ctx, &qqid.Message{Elements: seg.elems})
+		trimContent(part.Content)
+		parts = append(parts, part)
+	}
+
+	return parts
+}
+
+// splitSegments uploads the images and groups the elements between them.
+// Text runs without any visible content are dropped.
+func (mc *MessageConverter) splitSegments(ctx context.Context, elems []message.IMessageElement) []*segment {
+	segments := make([]*segment, 0)
+
+	var run []message.IMessageElement
+	flush := func() {
+		if strings.TrimSpace(toContent(run)) != "" {
+			segments = append(segments, &segment{elems: run})
+		}
+		run = nil
+	}
+
+	for _, elem := range elems {
+		if elem.Type() != message.Image {
+			run = append(run, elem)
+			continue
+		}
+		flush()
+
+		part, err := mc.reploadAttachment(ctx, elem)
+		if err != nil {
+			part = mc.makeMediaFailure(ctx, err)
+		}
+		segments = append(segments, &segment{media: part})
+	}
+	flush()
+
+	return segments
+}
+
+// renderInline renders all segments as a single HTML message. It returns nil
+// when an image can't be referenced from HTML, which is the case for encrypted media.
+func (mc *MessageConverter) renderInline(ctx context.Context, segments []*segment) *bridgev2.ConvertedMessagePart {
+	var body, formatted strings.Builder
+	mentions := &event.Mentions{}
+	for _, seg := range segments {
+		switch {
+		case seg.media == nil:
+			text := mc.renderText(ctx, seg.elems)
+			body.WriteString(text.Body)
+			if text.FormattedBody != "" {
+				formatted.WriteString(text.FormattedBody)
+			} else {
+				formatted.WriteString(html.EscapeString(text.Body))
+			}
+			mentions.Room = mentions.Room || text.Mentions.Room
+			for _, userID := range text.Mentions.UserIDs {
+				mentions.Add(userID)
+			}
+		case seg.media.Content.MsgType != event.MsgImage:
+			// Failed uploads are replaced with their placeholder
+			body.WriteString("[Image]")
+			formatted.WriteString("[Image]")
+		case seg.media.Content.URL == "":
+			return nil
+		default:
+			body.WriteString("[Image]")
+			fmt.Fprintf(&formatted, `<img src="%s" alt="%s">`, seg.media.Content.URL, html.EscapeString(seg.media.Content.FileName))
+		}
+	}
+
+	content := &event.MessageEventContent{
+		MsgType:       event.MsgText,
+		Format:        event.FormatHTML,
+		Body:          body.String(),
+		FormattedBody: formatted.String(),
+		Mentions:      mentions,
+	}
+	trimContent(content)
+
+	return &bridgev2.ConvertedMessagePart{
+		Type:    event.EventMessage,
+		Content: content,
+	}
+}
+
+// trimContent removes the whitespace and line breaks QQ puts around images.
+func trimContent(content *event.MessageEventContent) {
+	content.Body = strings.TrimSpace(content.Body)
+
+	formatted := content.FormattedBody
+	for {
+		trimmed := strings.TrimSpace(formatted)
+		trimmed = strings.TrimPrefix(trimmed, "<br>")
+		trimmed = strings.TrimSuffix(trimmed, "<br>")
+		if trimmed == formatted {
+			break
+		}
+		formatted = trimmed
+	}
+	content.FormattedBody = formatted
+}
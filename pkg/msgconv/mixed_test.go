@@ -0,0 +1,77 @@
+package msgconv
+
+import (
+	"context"
+	"testing"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"maunium.net/go/mautrix/event"
+)
+
+func TestTrimContent(t *testing.T) {
+	tests := []struct {
+		body          string
+		formatted     string
+		wantBody      string
+		wantFormatted string
+	}{
+		{"text", "", "text", ""},
+		{"\n text \n", " text <br>", "text", "text"},
+		{"\n\ntext\n\n", "<br><br>text<br><br>", "text", "text"},
+		{"a\nb", "a<br>b", "a\nb", "a<br>b"},
+		{"\n", "<br> <br>", "", ""},
+		{" [Image] ", ` <br><img src="mxc://a/b"> `, "[Image]", `<img src="mxc://a/b">`},
+	}
+
+	for _, tt := range tests {
+		content := &event.MessageEventContent{Body: tt.body, FormattedBody: tt.formatted}
+		trimContent(content)
+		if content.Body != tt.wantBody {
+			t.Errorf("trimContent(%q) body = %q, want %q", tt.body, content.Body, tt.wantBody)
+		}
+		if content.FormattedBody != tt.wantFormatted {
+			t.Errorf("trimContent(%q) formatted body = %q, want %q", tt.formatted, content.FormattedBody, tt.wantFormatted)
+		}
+	}
+}
+
+func TestSplitSegmentsText(t *testing.T) {
+	tests := []struct {
+		name  string
+		elems []message.IMessageElement
+		want  []string
+	}{
+		{
+			name:  "empty",
+			elems: nil,
+			want:  []string{},
+		},
+		{
+			name:  "one run",
+			elems: []message.IMessageElement{message.NewText("a"), message.NewFace(0), message.NewText("b")},
+			want:  []string{"a|[face 0]|b"},
+		},
+		{
+			name:  "whitespace only",
+			elems: []message.IMessageElement{message.NewText(" \n ")},
+			want:  []string{},
+		},
+	}
+
+	mc := &MessageConverter{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments := mc.splitSegments(context.Background(), tt.elems)
+			if len(segments) != len(tt.want) {
+				t.Fatalf("got %d segments, want %d", len(segments), len(tt.want))
+			}
+			for i, seg := range segments {
+				if seg.media != nil {
+					t.Errorf("segment %d is media, want text", i)
+				} else if got := describeElements(seg.elems); got != tt.want[i] {
+					t.Errorf("segment %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -1,21 +1,51 @@
 package msgconv
 
 import (
+	"context"
+	"image"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru/v2"
 	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/networkid"
 	"maunium.net/go/mautrix/format"
+	"maunium.net/go/mautrix/id"
 )
 
 type MessageConverter struct {
 	Bridge      *bridgev2.Bridge
 	MaxFileSize int64
 	HTMLParser  *format.HTMLParser
+
+	// How many levels of nested merged forwards to fetch, 0 disables fetching
+	ForwardDepth int
+	// URL of face images with {id} as placeholder, empty disables inline faces
+	FaceImageURL string
+	// Link for shared locations with {lat} and {lng} as placeholders
+	LocationURL string
+	// Map tile URL with {z}, {x} and {y} as placeholders, empty disables location thumbnails
+	MapTileURL string
+	MapZoom    int
+	// How to bridge messages mixing text and images, see MixedModeParts and MixedModeInline
+	MixedMessages string
+
+	// Resolves the Matrix user and name of QQ users for mentions, replaced in tests
+	lookupUser func(ctx context.Context, user networkid.UserID) (id.UserID, string, error)
+
+	faceURIs     sync.Map
+	stickerCache *lru.Cache[string, *sticker]
+	tileCache    *lru.Cache[string, image.Image]
 }
 
 func NewMessageConverter(br *bridgev2.Bridge) *MessageConverter {
 	mc := &MessageConverter{
 		Bridge:      br,
 		MaxFileSize: 100 * 1024 * 1024,
+
+		stickerCache: newStickerCache(),
+		tileCache:    newTileCache(),
 	}
+	mc.lookupUser = mc.getBasicUserInfo
 	mc.HTMLParser = &format.HTMLParser{
 		PillConverter: mc.convertPill,
 		Newline:       "\n",
@@ -0,0 +1,125 @@
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/event"
+)
+
+// Only ASCII is matched, as QQ users rarely put spaces between links and CJK text
+var urlRegex = regexp.MustCompile(`(?i)\bhttps?://[a-z0-9\-._~:/?#\[\]@!$&'()*+,;=%]+`)
+
+// renderText renders QQ elements as a plain body and HTML. Mentions are turned
+// into pills where their element is, and faces are rendered inline.
+func (mc *MessageConverter) renderText(ctx context.Context, elems []message.IMessageElement) *event.MessageEventContent {
+	var body, formatted strings.Builder
+	mentions := &event.Mentions{}
+
+	// Replies mention the replied user first, which isn't part of the text
+	skip := qqid.ReplyMentionIndex(elems)
+
+	for i, elem := range elems {
+		switch e := elem.(type) {
+		case *message.ReplyElement:
+		case *message.TextElement:
+			body.WriteString(e.Content)
+			formatted.WriteString(linkify(e.Content))
+		case *message.AtElement:
+			if i == skip {
+				continue
+			}
+			if e.TargetUin == 0 {
+				mentions.Room = true
+				body.WriteString("@room")
+				formatted.WriteString("@room")
+				continue
+			}
+
+			uin := fmt.Sprint(e.TargetUin)
+			mxid, name, err := mc.lookupUser(ctx, qqid.MakeUserID(uin))
+			// The display text of the mention is the group card
+			if display := strings.TrimPrefix(e.Display, "@"); display != "" {
+				name = display
+			}
+			if name == "" {
+				name = uin
+			}
+			if err != nil {
+				zerolog.Ctx(ctx).Err(err).Str("id", uin).Msg("Failed to get user info")
+				body.WriteString("@" + name)
+				formatted.WriteString(html.EscapeString("@" + name))
+				continue
+			}
+			mentions.Add(mxid)
+			body.WriteString(name)
+			fmt.Fprintf(&formatted, `<a href="%s">%s</a>`, mxid.URI().MatrixToURL(), html.EscapeString(name))
+		case *message.FaceElement:
+			body.WriteString(faceElementText(e))
+			formatted.WriteString(mc.faceHTML(ctx, e))
+		default:
+			text := toContent([]message.IMessageElement{elem})
+			body.WriteString(text)
+			formatted.WriteString(escapeHTML(text))
+		}
+	}
+
+	content := &event.MessageEventContent{
+		MsgType:  event.MsgText,
+		Body:     body.String(),
+		Mentions: mentions,
+	}
+	// Plain text is left without HTML
+	if formatted.String() != html.EscapeString(content.Body) {
+		content.Format = event.FormatHTML
+		content.FormattedBody = formatted.String()
+	}
+
+	return content
+}
+
+// linkify escapes text as HTML, turning URLs into links and newlines into line breaks.
+func linkify(text string) string {
+	var formatted strings.Builder
+
+	last := 0
+	for _, loc := range urlRegex.FindAllStringIndex(text, -1) {
+		start, end := loc[0], loc[0]+len(trimURL(text[loc[0]:loc[1]]))
+		formatted.WriteString(escapeHTML(text[last:start]))
+		url := html.EscapeString(text[start:end])
+		fmt.Fprintf(&formatted, `<a href="%s">%s</a>`, url, url)
+		last = end
+	}
+	formatted.WriteString(escapeHTML(text[last:]))
+
+	return formatted.String()
+}
+
+// trimURL removes trailing punctuation that is more likely part of the sentence than the URL.
+func trimURL(url string) string {
+	for len(url) > 0 {
+		last := url[len(url)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'\"*", last) >= 0:
+			url = url[:len(url)-1]
+		case last == ')' && strings.Count(url, "(") < strings.Count(url, ")"):
+			url = url[:len(url)-1]
+		case last == ']' && strings.Count(url, "[") < strings.Count(url, "]"):
+			url = url[:len(url)-1]
+		default:
+			return url
+		}
+	}
+	return url
+}
+
+func escapeHTML(s string) string {
+	return strings.ReplaceAll(html.EscapeString(s), "\n", "<br>")
+}
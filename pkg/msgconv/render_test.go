@@ -0,0 +1,202 @@
+package msgconv
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"maunium.net/go/mautrix/bridgev2/networkid"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestTrimURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com", "https://example.com"},
+		{"https://example.com.", "https://example.com"},
+		{"https://example.com/?q=1,", "https://example.com/?q=1"},
+		{"https://example.com!?", "https://example.com"},
+		{"https://example.com/a_(b)", "https://example.com/a_(b)"},
+		{"https://example.com/a)", "https://example.com/a"},
+		{"https://example.com/a]", "https://example.com/a"},
+		{"https://example.com/[a]", "https://example.com/[a]"},
+		{"https://example.com/a).", "https://example.com/a"},
+		{"...", ""},
+	}
+
+	for _, tt := range tests {
+		if got := trimURL(tt.url); got != tt.want {
+			t.Errorf("trimURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLinkify(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"a < b & c", "a &lt; b &amp; c"},
+		{"line 1\nline 2", "line 1<br>line 2"},
+		{
+			"see https://example.com/a?b=1&c=2.",
+			`see <a href="https://example.com/a?b=1&amp;c=2">https://example.com/a?b=1&amp;c=2</a>.`,
+		},
+		{
+			"(https://example.com/a) and http://example.org",
+			`(<a href="https://example.com/a">https://example.com/a</a>) and <a href="http://example.org">http://example.org</a>`,
+		},
+		{
+			"链接https://example.com/中文",
+			`链接<a href="https://example.com/">https://example.com/</a>中文`,
+		},
+		{"ftp://example.com", "ftp://example.com"},
+		{`https://example.com/"onclick="x`, `<a href="https://example.com/">https://example.com/</a>&#34;onclick=&#34;x`},
+	}
+
+	for _, tt := range tests {
+		if got := linkify(tt.text); got != tt.want {
+			t.Errorf("linkify(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	tests := []struct {
+		name      string
+		elems     []message.IMessageElement
+		body      string
+		formatted string
+		room      bool
+	}{
+		{
+			name:  "plain text has no HTML",
+			elems: []message.IMessageElement{message.NewText("hello world")},
+			body:  "hello world",
+		},
+		{
+			name:  "escaped characters alone have no HTML",
+			elems: []message.IMessageElement{message.NewText("a < b")},
+			body:  "a < b",
+		},
+		{
+			name:      "links",
+			elems:     []message.IMessageElement{message.NewText("go to https://example.com")},
+			body:      "go to https://example.com",
+			formatted: `go to <a href="https://example.com">https://example.com</a>`,
+		},
+		{
+			name:      "line breaks",
+			elems:     []message.IMessageElement{message.NewText("a\nb")},
+			body:      "a\nb",
+			formatted: "a<br>b",
+		},
+		{
+			name:  "faces",
+			elems: []message.IMessageElement{message.NewText("wow "), message.NewFace(0), message.NewFace(16)},
+			body:  "wow 😮/酷",
+		},
+		{
+			name:  "room mention",
+			elems: []message.IMessageElement{message.NewAt(0), message.NewText(" hello")},
+			body:  "@room hello",
+			room:  true,
+		},
+		{
+			name: "reply mention is dropped",
+			elems: []message.IMessageElement{
+				&message.ReplyElement{ReplySeq: 1, SenderUin: 12345},
+				message.NewAt(12345),
+				message.NewText("hi"),
+			},
+			body: "hi",
+		},
+		{
+			name: "only the first mention of a reply is dropped",
+			elems: []message.IMessageElement{
+				&message.ReplyElement{ReplySeq: 1, SenderUin: 12345},
+				message.NewAt(12345),
+				message.NewAt(0),
+				message.NewText(" hi"),
+			},
+			body: "@room hi",
+			room: true,
+		},
+		{
+			name: "typed first mention of a reply is kept",
+			elems: []message.IMessageElement{
+				&message.ReplyElement{ReplySeq: 1, SenderUin: 12345},
+				message.NewAt(0),
+				message.NewText(" hi"),
+			},
+			body: "@room hi",
+			room: true,
+		},
+	}
+
+	mc := &MessageConverter{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := mc.renderText(context.Background(), tt.elems)
+			if content.Body != tt.body {
+				t.Errorf("body = %q, want %q", content.Body, tt.body)
+			}
+			if content.FormattedBody != tt.formatted {
+				t.Errorf("formatted body = %q, want %q", content.FormattedBody, tt.formatted)
+			}
+			if tt.formatted != "" && content.Format != event.FormatHTML {
+				t.Errorf("format = %q, want %q", content.Format, event.FormatHTML)
+			}
+			if content.Mentions.Room != tt.room {
+				t.Errorf("room mention = %v, want %v", content.Mentions.Room, tt.room)
+			}
+		})
+	}
+}
+
+func TestRenderTextMentions(t *testing.T) {
+	mc := &MessageConverter{
+		lookupUser: func(ctx context.Context, user networkid.UserID) (id.UserID, string, error) {
+			if user == "404" {
+				return "", "", errors.New("no such ghost")
+			}
+			return id.UserID("@qq_" + string(user) + ":example.com"), "Ghost " + string(user), nil
+		},
+	}
+
+	content := mc.renderText(context.Background(), []message.IMessageElement{
+		message.NewText("hi "),
+		message.NewAt(12345, "@Alice"),
+		message.NewText(", see https://example.com & <b>"),
+		message.NewAt(404, "@Bob <3"),
+		message.NewText("\nbye "),
+		message.NewAt(67890, "@"),
+	})
+
+	wantBody := "hi Alice, see https://example.com & <b>@Bob <3\nbye Ghost 67890"
+	if content.Body != wantBody {
+		t.Errorf("body = %q, want %q", content.Body, wantBody)
+	}
+	wantFormatted := `hi <a href="https://matrix.to/#/@qq_12345:example.com">Alice</a>` +
+		`, see <a href="https://example.com">https://example.com</a> &amp; &lt;b&gt;` +
+		`@Bob &lt;3` +
+		`<br>bye <a href="https://matrix.to/#/@qq_67890:example.com">Ghost 67890</a>`
+	if content.FormattedBody != wantFormatted {
+		t.Errorf("formatted body = %q, want %q", content.FormattedBody, wantFormatted)
+	}
+	if content.Format != event.FormatHTML {
+		t.Errorf("format = %q, want %q", content.Format, event.FormatHTML)
+	}
+
+	wantMentions := []id.UserID{"@qq_12345:example.com", "@qq_67890:example.com"}
+	if !slices.Equal(content.Mentions.UserIDs, wantMentions) || content.Mentions.Room {
+		t.Errorf("mentions = %+v, want users %v", content.Mentions, wantMentions)
+	}
+}
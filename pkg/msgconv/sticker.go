@@ -0,0 +1,150 @@
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/duo/matrix-qq/pkg/qqid"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"github.com/gabriel-vasile/mimetype"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/rs/zerolog"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// Stickers are displayed no larger than this
+const maxStickerSize = 256
+
+// sticker is an uploaded sticker, only the mxc URI is kept as the same stickers tend to be sent over and over
+type sticker struct {
+	URI      id.ContentURIString
+	MimeType string
+	Size     int
+	Width    int
+	Height   int
+}
+
+func newStickerCache() *lru.Cache[string, *sticker] {
+	cache, _ := lru.New[string, *sticker](1024)
+	return cache
+}
+
+func (mc *MessageConverter) convertStickerMessage(ctx context.Context, msg *qqid.Message) *bridgev2.ConvertedMessagePart {
+	var img *message.ImageElement
+	for _, elem := range msg.Elements {
+		if v, ok := elem.(*message.ImageElement); ok {
+			img = v
+			break
+		}
+	}
+	if img == nil {
+		return mc.convertTextMessage(ctx, msg)
+	}
+
+	s, err := mc.uploadSticker(ctx, img)
+	if err != nil {
+		return mc.makeMediaFailure(ctx, err)
+	}
+
+	content := &event.MessageEventContent{
+		Body: img.Summary,
+		URL:  s.URI,
+		Info: &event.FileInfo{
+			MimeType: s.MimeType,
+			Size:     s.Size,
+		},
+	}
+	if content.Body == "" {
+		content.Body = "[Sticker]"
+	}
+	content.Info.Width, content.Info.Height = scaleSticker(s.Width, s.Height)
+
+	return &bridgev2.ConvertedMessagePart{
+		Type:    event.EventSticker,
+		Content: content,
+	}
+}
+
+// uploadSticker downloads a sticker, converts animated images to WebP and uploads it.
+// The sticker is uploaded once and the mxc URI is reused afterwards.
+func (mc *MessageConverter) uploadSticker(ctx context.Context, img *message.ImageElement) (*sticker, error) {
+	key := stickerID(img)
+	if cached, ok := mc.stickerCache.Get(key); ok {
+		return cached, nil
+	}
+
+	data, err := qqid.GetBytes(img.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download sticker: %w", err)
+	}
+
+	s := &sticker{
+		MimeType: mimetype.Detect(data).String(),
+		Width:    int(img.Width),
+		Height:   int(img.Height),
+	}
+	if s.Width == 0 || s.Height == 0 {
+		if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			s.Width, s.Height = cfg.Width, cfg.Height
+		}
+	}
+
+	if isAnimated(data, s.MimeType) {
+		if converted, err := animated2webp(data, mimetype.Lookup(s.MimeType).Extension()); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("sticker_id", key).Msg("Failed to convert animated sticker, sending it as is")
+		} else {
+			data = converted
+			s.MimeType = "image/webp"
+		}
+	}
+	s.Size = len(data)
+
+	fileName := key + mimetype.Lookup(s.MimeType).Extension()
+	if s.URI, _, err = mc.Bridge.Bot.UploadMedia(ctx, "", data, fileName, s.MimeType); err != nil {
+		return nil, fmt.Errorf("failed to upload sticker: %w", err)
+	}
+
+	mc.stickerCache.Add(key, s)
+
+	return s, nil
+}
+
+func stickerID(img *message.ImageElement) string {
+	if len(img.Md5) > 0 {
+		return hex.EncodeToString(img.Md5)
+	} else if img.FileUUID != "" {
+		return img.FileUUID
+	}
+	return img.ImageID
+}
+
+func isAnimated(data []byte, mimeType string) bool {
+	switch mimeType {
+	case "image/vnd.mozilla.apng":
+		return true
+	case "image/gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		return err == nil && len(g.Image) > 1
+	default:
+		return false
+	}
+}
+
+func scaleSticker(width, height int) (int, int) {
+	if width <= maxStickerSize && height <= maxStickerSize {
+		return width, height
+	}
+	if width >= height {
+		return maxStickerSize, height * maxStickerSize / width
+	}
+	return width * maxStickerSize / height, maxStickerSize
+}
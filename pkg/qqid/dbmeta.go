@@ -3,11 +3,16 @@ package qqid
 import (
 	"github.com/LagrangeDev/LagrangeGo/client/auth"
 	"go.mau.fi/util/jsontime"
+	"maunium.net/go/mautrix/event"
 )
 
 type UserLoginMetadata struct {
 	Device *auth.DeviceInfo `json:"device"`
 	Token  []byte           `json:"token"`
+
+	// Pending friend requests by UIN with the UID needed to answer them,
+	// QQ has no API to list them again after a restart
+	FriendRequests map[uint32]string `json:"friend_requests,omitempty"`
 }
 
 type GhostMetadata struct {
@@ -17,4 +22,28 @@ type GhostMetadata struct {
 type PortalMetadata struct {
 	ChatType ChatType      `json:"chat_type"`
 	LastSync jsontime.Unix `json:"last_sync,omitempty"`
+	MuteAll  bool          `json:"mute_all,omitempty"`
+
+	LastNoticeID string `json:"last_notice_id,omitempty"`
+}
+
+type MessageMetadata struct {
+	Random    uint32 `json:"random,omitempty"`
+	ClientSeq uint32 `json:"client_seq,omitempty"`
+	Text      string `json:"text,omitempty"`
+
+	// The text of this part when the message was split into several parts
+	PartText string `json:"part_text,omitempty"`
+	// The Matrix media type of this part, so merged forwards know which events to upload again
+	MediaType event.MessageType `json:"media_type,omitempty"`
+}
+
+// MediaMsgType returns the media type of a Matrix message, or an empty type if it has none.
+func MediaMsgType(evtType event.Type, content *event.MessageEventContent) event.MessageType {
+	if evtType == event.EventSticker {
+		return event.MessageType(event.EventSticker.Type)
+	} else if !content.MsgType.IsMedia() || (content.URL == "" && content.File == nil) {
+		return ""
+	}
+	return content.MsgType
 }
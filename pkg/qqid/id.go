@@ -28,6 +28,7 @@ const (
 	MsgFile     MessageType = "file"
 	MsgLocation MessageType = "location"
 	MsgApp      MessageType = "app"
+	MsgForward  MessageType = "forward"
 	MsgRevoke   MessageType = "revoke"
 )
 
@@ -46,6 +47,9 @@ func ParseMessageType(elems []message.IMessageElement) MessageType {
 	for _, elem := range elems {
 		switch elem.Type() {
 		case message.Image:
+			if img, ok := elem.(*message.ImageElement); ok && IsSticker(img) && isStandalone(elems) {
+				return MsgSticker
+			}
 			return MsgImage
 		case message.Voice:
 			return MsgAudio
@@ -54,7 +58,11 @@ func ParseMessageType(elems []message.IMessageElement) MessageType {
 		case message.File:
 			return MsgFile
 		case message.Forward:
+			return MsgForward
 		case message.Service, message.LightApp:
+			if ParseLocation(elem) != nil {
+				return MsgLocation
+			}
 			return MsgApp
 		}
 	}
@@ -62,6 +70,52 @@ func ParseMessageType(elems []message.IMessageElement) MessageType {
 	return MsgText
 }
 
+// IsSticker reports whether an image was sent as a sticker rather than a photo.
+func IsSticker(img *message.ImageElement) bool {
+	return img.SubType == 1 || img.Summary == "[动画表情]"
+}
+
+// isStandalone reports whether elems contain a single element apart from reply headers.
+func isStandalone(elems []message.IMessageElement) bool {
+	skip := ReplyMentionIndex(elems)
+
+	count := 0
+	for i, elem := range elems {
+		switch elem.(type) {
+		case *message.ReplyElement:
+		case *message.AtElement:
+			if i != skip {
+				count++
+			}
+		default:
+			count++
+		}
+	}
+	return count == 1
+}
+
+// ReplyMentionIndex returns the index of the mention QQ adds to replies, or -1.
+// Replies mention the replied user first, any other first mention was typed by the sender.
+func ReplyMentionIndex(elems []message.IMessageElement) int {
+	if len(elems) == 0 {
+		return -1
+	}
+	reply, ok := elems[0].(*message.ReplyElement)
+	if !ok {
+		return -1
+	}
+
+	for i, elem := range elems {
+		if at, ok := elem.(*message.AtElement); ok {
+			if at.TargetUin == reply.SenderUin {
+				return i
+			}
+			return -1
+		}
+	}
+	return -1
+}
+
 func MakeUserID(id string) networkid.UserID {
 	return networkid.UserID(id)
 }
@@ -0,0 +1,49 @@
+package qqid
+
+import (
+	"testing"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+)
+
+func TestIsSticker(t *testing.T) {
+	if IsSticker(&message.ImageElement{}) {
+		t.Error("plain image detected as sticker")
+	}
+	if IsSticker(&message.ImageElement{Summary: "[图片]"}) {
+		t.Error("photo detected as sticker")
+	}
+	if !IsSticker(&message.ImageElement{SubType: 1}) {
+		t.Error("sticker sub type not detected")
+	}
+	if !IsSticker(&message.ImageElement{Summary: "[动画表情]"}) {
+		t.Error("animated sticker summary not detected")
+	}
+}
+
+func TestParseMessageType(t *testing.T) {
+	sticker := &message.ImageElement{SubType: 1}
+	reply := &message.ReplyElement{ReplySeq: 1, SenderUin: 1}
+
+	cases := map[string]struct {
+		elems []message.IMessageElement
+		want  MessageType
+	}{
+		"text":                     {[]message.IMessageElement{message.NewText("hi")}, MsgText},
+		"photo":                    {[]message.IMessageElement{&message.ImageElement{}}, MsgImage},
+		"sticker":                  {[]message.IMessageElement{sticker}, MsgSticker},
+		"sticker with text":        {[]message.IMessageElement{message.NewText("hi"), sticker}, MsgImage},
+		"reply with sticker":       {[]message.IMessageElement{reply, message.NewAt(1), sticker}, MsgSticker},
+		"reply with extra mention": {[]message.IMessageElement{reply, message.NewAt(1), message.NewAt(2), sticker}, MsgImage},
+		"reply with typed mention": {[]message.IMessageElement{reply, message.NewAt(2), sticker}, MsgImage},
+		"mention without reply":    {[]message.IMessageElement{message.NewAt(1), sticker}, MsgImage},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := ParseMessageType(c.elems); got != c.want {
+				t.Errorf("ParseMessageType() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
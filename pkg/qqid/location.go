@@ -0,0 +1,123 @@
+package qqid
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+	"github.com/tidwall/gjson"
+)
+
+type Location struct {
+	Name      string
+	Address   string
+	Latitude  float64
+	Longitude float64
+}
+
+// ParseLocation extracts the location shared by a LightApp or legacy XML location card.
+func ParseLocation(elem message.IMessageElement) *Location {
+	switch v := elem.(type) {
+	case *message.LightAppElement:
+		return parseLightAppLocation(v.Content)
+	case *message.XMLElement:
+		return parseXMLLocation(v.Content)
+	}
+	return nil
+}
+
+func parseLightAppLocation(content string) *Location {
+	if gjson.Get(content, "view").String() != "LocationShare" {
+		return nil
+	}
+
+	lat := gjson.Get(content, "meta.*.lat")
+	lng := gjson.Get(content, "meta.*.lng")
+	if !lat.Exists() || !lng.Exists() {
+		return nil
+	}
+
+	return &Location{
+		Name:      gjson.Get(content, "meta.*.name").String(),
+		Address:   gjson.Get(content, "meta.*.address").String(),
+		Latitude:  lat.Float(),
+		Longitude: lng.Float(),
+	}
+}
+
+// Legacy location cards link to Tencent Maps, either as
+// ...?pointx=<lng>&pointy=<lat>&name=...&addr=... or
+// ...?marker=coord:<lat>,<lng>;title:...;addr:...
+func parseXMLLocation(content string) *Location {
+	var card struct {
+		URL string `xml:"url,attr"`
+	}
+	if err := xml.Unmarshal([]byte(content), &card); err != nil || card.URL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(card.URL)
+	if err != nil || !strings.Contains(u.Host, "map.qq.com") {
+		return nil
+	}
+	query := u.Query()
+
+	if query.Has("pointx") && query.Has("pointy") {
+		lng, err1 := strconv.ParseFloat(query.Get("pointx"), 64)
+		lat, err2 := strconv.ParseFloat(query.Get("pointy"), 64)
+		if err1 != nil || err2 != nil {
+			return nil
+		}
+		return &Location{
+			Name:      query.Get("name"),
+			Address:   query.Get("addr"),
+			Latitude:  lat,
+			Longitude: lng,
+		}
+	}
+
+	if marker := markerParam(u.RawQuery); marker != "" {
+		loc := &Location{}
+		hasCoord := false
+		for _, field := range strings.Split(marker, ";") {
+			key, value, _ := strings.Cut(field, ":")
+			switch key {
+			case "coord":
+				latStr, lngStr, _ := strings.Cut(value, ",")
+				lat, err1 := strconv.ParseFloat(latStr, 64)
+				lng, err2 := strconv.ParseFloat(lngStr, 64)
+				if err1 != nil || err2 != nil {
+					return nil
+				}
+				loc.Latitude, loc.Longitude = lat, lng
+				hasCoord = true
+			case "title":
+				loc.Name = value
+			case "addr":
+				loc.Address = value
+			}
+		}
+		if hasCoord {
+			return loc
+		}
+	}
+
+	return nil
+}
+
+// markerParam returns the marker parameter of a query. url.ParseQuery can't be
+// used, as it drops parameters with unescaped semicolons, which markers contain.
+func markerParam(rawQuery string) string {
+	for _, param := range strings.Split(rawQuery, "&") {
+		if value, ok := strings.CutPrefix(param, "marker="); ok {
+			marker, err := url.QueryUnescape(value)
+			if err != nil {
+				return ""
+			}
+			return marker
+		}
+	}
+	return ""
+}
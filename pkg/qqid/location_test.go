@@ -0,0 +1,79 @@
+package qqid
+
+import (
+	"testing"
+
+	"github.com/LagrangeDev/LagrangeGo/message"
+)
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		elem message.IMessageElement
+		want *Location
+	}{
+		{
+			name: "light app",
+			elem: &message.LightAppElement{Content: `{"app":"com.tencent.map","view":"LocationShare","meta":{"Location.Search":{"name":"天安门","address":"北京市东城区","lat":"39.908823","lng":"116.397470"}}}`},
+			want: &Location{Name: "天安门", Address: "北京市东城区", Latitude: 39.908823, Longitude: 116.39747},
+		},
+		{
+			name: "light app with numbers",
+			elem: &message.LightAppElement{Content: `{"view":"LocationShare","meta":{"Location.Search":{"lat":31.2,"lng":121.5}}}`},
+			want: &Location{Latitude: 31.2, Longitude: 121.5},
+		},
+		{
+			name: "light app of another view",
+			elem: &message.LightAppElement{Content: `{"view":"news","meta":{"news":{"lat":1,"lng":2}}}`},
+		},
+		{
+			name: "light app without coordinates",
+			elem: &message.LightAppElement{Content: `{"view":"LocationShare","meta":{"Location.Search":{"name":"x"}}}`},
+		},
+		{
+			name: "xml with pointx and pointy",
+			elem: &message.XMLElement{Content: `<?xml version="1.0" encoding="utf-8"?><msg serviceID="32" url="https://map.qq.com/?type=marker&amp;isopeninfowin=1&amp;markertype=1&amp;pointx=116.39747&amp;pointy=39.908823&amp;name=%E5%A4%A9%E5%AE%89%E9%97%A8&amp;addr=%E5%8C%97%E4%BA%AC"><item/></msg>`},
+			want: &Location{Name: "天安门", Address: "北京", Latitude: 39.908823, Longitude: 116.39747},
+		},
+		{
+			name: "xml with marker",
+			elem: &message.XMLElement{Content: `<msg serviceID="32" url="https://apis.map.qq.com/uri/v1/marker?marker=coord:31.2,121.5;title:Bund;addr:Shanghai"></msg>`},
+			want: &Location{Name: "Bund", Address: "Shanghai", Latitude: 31.2, Longitude: 121.5},
+		},
+		{
+			name: "xml marker without coordinates",
+			elem: &message.XMLElement{Content: `<msg url="https://apis.map.qq.com/uri/v1/marker?marker=title:Bund"></msg>`},
+		},
+		{
+			name: "xml with invalid coordinates",
+			elem: &message.XMLElement{Content: `<msg url="https://map.qq.com/?pointx=abc&amp;pointy=1"></msg>`},
+		},
+		{
+			name: "xml of another site",
+			elem: &message.XMLElement{Content: `<msg url="https://example.com/?pointx=1&amp;pointy=2"></msg>`},
+		},
+		{
+			name: "invalid xml",
+			elem: &message.XMLElement{Content: `<msg`},
+		},
+		{
+			name: "text",
+			elem: message.NewText("39.9,116.4"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseLocation(tt.elem)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("ParseLocation() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil || *got != *tt.want {
+				t.Errorf("ParseLocation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}